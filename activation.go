@@ -0,0 +1,127 @@
+// +build !windows
+
+package svc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START).
+// It's a variable so tests can pass descriptors without clobbering stdio.
+var listenFdsStart = 3
+
+// activation holds the sockets inherited through socket activation. Entries in
+// listeners and packetConns are nil where the socket at that index is of the other kind.
+type activation struct {
+	names       []string
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+}
+
+// sdListenFds collects the sockets systemd passed to this process as described in
+// sd_listen_fds(3). LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES are always removed
+// from the environment so child processes don't mistake the sockets for their own.
+//
+// upgrade reports whether this process was started by an upgrade, which passes its
+// listeners the same way but can't know the new process's pid to set LISTEN_PID.
+func sdListenFds(upgrade bool) (activation, error) {
+	pidEnv, fdsEnv, namesEnv := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if err := os.Unsetenv(key); err != nil {
+			return activation{}, err
+		}
+	}
+
+	if fdsEnv == "" || (pidEnv == "" && !upgrade) {
+		return activation{}, nil
+	}
+
+	if pidEnv != "" {
+		pid, err := strconv.Atoi(pidEnv)
+		if err != nil {
+			return activation{}, fmt.Errorf("svc: invalid LISTEN_PID %q: %w", pidEnv, err)
+		}
+		if pid != os.Getpid() {
+			return activation{}, nil
+		}
+	}
+
+	n, err := strconv.Atoi(fdsEnv)
+	if err != nil || n < 0 {
+		return activation{}, fmt.Errorf("svc: invalid LISTEN_FDS %q", fdsEnv)
+	}
+
+	var names []string
+	if namesEnv != "" {
+		names = strings.Split(namesEnv, ":")
+	}
+
+	a := activation{
+		names:       make([]string, n),
+		listeners:   make([]net.Listener, n),
+		packetConns: make([]net.PacketConn, n),
+	}
+
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		// systemd names unnamed sockets "unknown"
+		name := "unknown"
+		if i < len(names) {
+			name = names[i]
+		}
+		a.names[i] = name
+
+		// FileListener and FilePacketConn dup the descriptor,
+		// so the original is closed either way.
+		f := os.NewFile(uintptr(fd), name)
+		if l, err := net.FileListener(f); err == nil {
+			a.listeners[i] = l
+		} else if pc, err := net.FilePacketConn(f); err == nil {
+			a.packetConns[i] = pc
+		}
+		if err := f.Close(); err != nil {
+			return activation{}, err
+		}
+	}
+
+	return a, nil
+}
+
+// Listeners returns the inherited stream sockets by index.
+func (a activation) Listeners() []net.Listener {
+	return a.listeners
+}
+
+// PacketConns returns the inherited datagram sockets by index.
+func (a activation) PacketConns() []net.PacketConn {
+	return a.packetConns
+}
+
+// ListenersWithName returns the inherited stream sockets named name.
+func (a activation) ListenersWithName(name string) []net.Listener {
+	var ls []net.Listener
+	for i, l := range a.listeners {
+		if l != nil && a.names[i] == name {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
+
+// PacketConnsWithName returns the inherited datagram sockets named name.
+func (a activation) PacketConnsWithName(name string) []net.PacketConn {
+	var pcs []net.PacketConn
+	for i, pc := range a.packetConns {
+		if pc != nil && a.names[i] == name {
+			pcs = append(pcs, pc)
+		}
+	}
+	return pcs
+}
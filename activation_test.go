@@ -0,0 +1,148 @@
+// +build !windows
+
+package svc
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// passSockets places the sockets' descriptors where systemd would, starting
+// at a high descriptor number so stdio and the test's own files are untouched.
+func passSockets(t *testing.T, names string, sockets ...interface{ File() (*os.File, error) }) {
+	t.Helper()
+
+	const start = 100
+	prev := listenFdsStart
+	listenFdsStart = start
+	t.Cleanup(func() {
+		listenFdsStart = prev
+	})
+
+	for i, s := range sockets {
+		f, err := s.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Dup2(int(f.Fd()), start+i); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setenv(t, "LISTEN_PID", strconv.Itoa(os.Getpid()))
+	setenv(t, "LISTEN_FDS", strconv.Itoa(len(sockets)))
+	setenv(t, "LISTEN_FDNAMES", names)
+}
+
+func TestRunSocketActivation(t *testing.T) {
+	// arrange
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, l)
+
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, pc)
+
+	passSockets(t, "http:dns", l, pc)
+
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	var env Environment
+	prg.init = func(e Environment) error {
+		initCalled++
+		env = e
+		return nil
+	}
+
+	go func() {
+		sigChan <- syscall.SIGTERM
+	}()
+
+	// act
+	if err := Run(prg); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if v, ok := os.LookupEnv(key); ok {
+			t.Errorf("%s, want: unset got: %q", key, v)
+		}
+	}
+
+	listeners, packetConns := env.Listeners(), env.PacketConns()
+	if len(listeners) != 2 || listeners[0] == nil || listeners[1] != nil {
+		t.Fatalf("Listeners, want: [listener <nil>] got: %v", listeners)
+	}
+	if len(packetConns) != 2 || packetConns[0] != nil || packetConns[1] == nil {
+		t.Fatalf("PacketConns, want: [<nil> packetConn] got: %v", packetConns)
+	}
+
+	if got := env.ListenersWithName("http"); len(got) != 1 || got[0] != listeners[0] {
+		t.Errorf("ListenersWithName(http), want: [%v] got: %v", listeners[0], got)
+	}
+	if got := env.ListenersWithName("dns"); len(got) != 0 {
+		t.Errorf("ListenersWithName(dns), want: [] got: %v", got)
+	}
+	if got := env.PacketConnsWithName("dns"); len(got) != 1 || got[0] != packetConns[1] {
+		t.Errorf("PacketConnsWithName(dns), want: [%v] got: %v", packetConns[1], got)
+	}
+
+	// the inherited listener accepts connections made to the original
+	closeOnCleanup(t, listeners[0])
+	closeOnCleanup(t, packetConns[1])
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, conn)
+
+	accepted, err := listeners[0].Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, accepted)
+}
+
+func TestSdListenFdsOtherProcess(t *testing.T) {
+	setenv(t, "LISTEN_PID", "1")
+	setenv(t, "LISTEN_FDS", "1")
+
+	a, err := sdListenFds(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Listeners()) != 0 {
+		t.Errorf("Listeners, want: [] got: %v", a.Listeners())
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("LISTEN_FDS, want: unset")
+	}
+}
+
+func TestSdListenFdsInvalid(t *testing.T) {
+	setenv(t, "LISTEN_PID", strconv.Itoa(os.Getpid()))
+	setenv(t, "LISTEN_FDS", "two")
+
+	if _, err := sdListenFds(false); err == nil {
+		t.Error("sdListenFds with LISTEN_FDS=two, want: error got: <nil>")
+	}
+}
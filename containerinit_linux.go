@@ -0,0 +1,239 @@
+// +build linux
+
+package svc
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// procRoot is where the proc filesystem is mounted.
+const procRoot = "/proc"
+
+// containerReapGrace is how long a child process has to be a zombie before the
+// container init reaps it. Commands started with os/exec are usually waited for
+// as soon as they exit.
+var containerReapGrace = 5 * time.Second
+
+// containerInit reaps orphaned zombies and forwards stop signals while the
+// process is the init process of a container.
+type containerInit struct {
+	o       *options
+	sigc    chan os.Signal
+	done    chan struct{}
+	wg      sync.WaitGroup
+	zombies map[int]time.Time
+}
+
+// startContainerInit starts reaping zombies if container init mode is enabled for
+// this process, returning nil otherwise.
+func startContainerInit(o *options) (*containerInit, error) {
+	switch o.containerInit {
+	case ContainerInitAlways:
+	case ContainerInitAuto:
+		if os.Getpid() != 1 {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	c := &containerInit{
+		o:       o,
+		sigc:    make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+		zombies: make(map[int]time.Time),
+	}
+	signalNotify(c.sigc, syscall.SIGCHLD)
+
+	c.wg.Add(1)
+	go c.run()
+	return c, nil
+}
+
+func (c *containerInit) run() {
+	defer c.wg.Done()
+
+	// zombies are reaped once they've waited out the grace period, which may
+	// be long after the SIGCHLD for them
+	ticker := time.NewTicker(containerReapGrace / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.sigc:
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		if err := c.reap(time.Now()); err != nil {
+			c.o.logf("reaping zombies: %v", err)
+		}
+	}
+}
+
+// reap waits for the child processes which have been zombies for longer than the
+// grace period.
+func (c *containerInit) reap(now time.Time) error {
+	procs, err := readProcs()
+	if err != nil {
+		return err
+	}
+
+	self := os.Getpid()
+	seen := make(map[int]bool)
+	for _, p := range procs {
+		if p.ppid != self || p.state != "Z" {
+			continue
+		}
+		seen[p.pid] = true
+
+		first, ok := c.zombies[p.pid]
+		if !ok {
+			c.zombies[p.pid] = now
+			continue
+		}
+		if now.Sub(first) < containerReapGrace {
+			continue
+		}
+
+		var status syscall.WaitStatus
+		if _, err := syscall.Wait4(p.pid, &status, syscall.WNOHANG, nil); err != nil && err != syscall.ECHILD {
+			return err
+		}
+		delete(c.zombies, p.pid)
+	}
+
+	// forget zombies waited for by someone else
+	for pid := range c.zombies {
+		if !seen[pid] {
+			delete(c.zombies, pid)
+		}
+	}
+	return nil
+}
+
+// forward sends sig to the other processes in the process group. It does nothing
+// when c is nil.
+func (c *containerInit) forward(sig os.Signal) {
+	if c == nil {
+		return
+	}
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return
+	}
+	if err := signalGroup(syscall.Getpgrp(), s); err != nil {
+		c.o.logf("forwarding %v: %v", sig, err)
+	}
+}
+
+// stop stops reaping zombies. It does nothing when c is nil.
+func (c *containerInit) stop() {
+	if c == nil {
+		return
+	}
+	signal.Stop(c.sigc)
+	close(c.done)
+	c.wg.Wait()
+}
+
+// isContainerInit reports whether c is running. It's false when c is nil.
+func (c *containerInit) isContainerInit() bool {
+	return c != nil
+}
+
+// signalGroup sends sig to the processes in process group pgrp, except this one
+// and zombies.
+func signalGroup(pgrp int, sig syscall.Signal) error {
+	procs, err := readProcs()
+	if err != nil {
+		return err
+	}
+
+	self := os.Getpid()
+	for _, p := range procs {
+		if p.pgrp != pgrp || p.pid == self || p.state == "Z" {
+			continue
+		}
+		if err := syscall.Kill(p.pid, sig); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// procStat is the part of /proc/[pid]/stat the container init uses.
+type procStat struct {
+	pid   int
+	state string
+	ppid  int
+	pgrp  int
+}
+
+// readProcs reads the status of every process. Processes which exit while they're
+// being read are skipped.
+func readProcs() ([]procStat, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []procStat
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(procRoot, e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		p, ok := parseProcStat(string(b))
+		if !ok || p.pid != pid {
+			continue
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// parseProcStat parses the start of a /proc/[pid]/stat line:
+//
+//	pid (comm) state ppid pgrp ...
+//
+// comm may contain spaces and parentheses, so the fields after it are found from
+// the last ')'.
+func parseProcStat(s string) (procStat, bool) {
+	open := strings.IndexByte(s, '(')
+	end := strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return procStat{}, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(s[:open]))
+	if err != nil {
+		return procStat{}, false
+	}
+
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 3 {
+		return procStat{}, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, false
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return procStat{}, false
+	}
+	return procStat{pid: pid, state: fields[0], ppid: ppid, pgrp: pgrp}, true
+}
@@ -0,0 +1,27 @@
+// +build !windows,!linux
+
+package svc
+
+import (
+	"errors"
+	"os"
+)
+
+// containerInit is never running; container init mode is Linux only.
+type containerInit struct{}
+
+// startContainerInit returns an error for ContainerInitAlways and nil otherwise.
+func startContainerInit(o *options) (*containerInit, error) {
+	if o.containerInit == ContainerInitAlways {
+		return nil, errors.New("svc: container init mode is only supported on Linux")
+	}
+	return nil, nil
+}
+
+func (*containerInit) forward(os.Signal) {}
+
+func (*containerInit) stop() {}
+
+func (*containerInit) isContainerInit() bool {
+	return false
+}
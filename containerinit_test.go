@@ -0,0 +1,142 @@
+// +build linux
+
+package svc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	p, ok := parseProcStat("1234 (a) b) (c) Z 1 1234 1234 0 -1 4194560 78 0 0 0\n")
+	if !ok {
+		t.Fatal("parseProcStat failed")
+	}
+	want := procStat{pid: 1234, state: "Z", ppid: 1, pgrp: 1234}
+	if p != want {
+		t.Errorf("parseProcStat, want: %+v got: %+v", want, p)
+	}
+
+	if _, ok := parseProcStat("1234 (truncated"); ok {
+		t.Error("parseProcStat of a truncated line succeeded")
+	}
+}
+
+func TestContainerInitReap(t *testing.T) {
+	// arrange
+	grace := containerReapGrace
+	containerReapGrace = 0
+	defer func() {
+		containerReapGrace = grace
+	}()
+
+	// a child which is never waited for, like an orphan reparented to init
+	proc, err := os.StartProcess("/bin/true", []string{"true"}, &os.ProcAttr{})
+	if err != nil {
+		t.Skip("can't start /bin/true:", err)
+	}
+	stat := "/proc/" + strconv.Itoa(proc.Pid) + "/stat"
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		b, err := ioutil.ReadFile(stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := parseProcStat(string(b)); ok && p.state == "Z" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child didn't become a zombie")
+		}
+	}
+
+	c := &containerInit{o: testOptions(t), zombies: make(map[int]time.Time)}
+
+	// act
+	now := time.Now()
+	if err := c.reap(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.reap(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if _, err := os.Stat(stat); !os.IsNotExist(err) {
+		t.Errorf("zombie %d not reaped: %v", proc.Pid, err)
+	}
+	if len(c.zombies) != 0 {
+		t.Errorf("zombies, want: none got: %v", c.zombies)
+	}
+}
+
+func TestSignalGroup(t *testing.T) {
+	// arrange
+	cmd := exec.Command("sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Skip("can't start sleep:", err)
+	}
+
+	// act
+	err := signalGroup(cmd.Process.Pid, syscall.SIGTERM)
+
+	// assert
+	if err != nil {
+		t.Error(err)
+	}
+
+	waitErr := cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(waitErr, &exitErr) {
+		t.Fatalf("Wait, want: *exec.ExitError got: %v", waitErr)
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); !ok || !status.Signaled() || status.Signal() != syscall.SIGTERM {
+		t.Errorf("status, want: killed by SIGTERM got: %v", exitErr)
+	}
+}
+
+func TestRunContextContainerInit(t *testing.T) {
+	cases := []struct {
+		name string
+		mode ContainerInit
+		want bool
+	}{
+		{name: "off", mode: 0, want: false},
+		{name: "auto", mode: ContainerInitAuto, want: os.Getpid() == 1},
+		{name: "always", mode: ContainerInitAlways, want: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var startCalled, stopCalled, initCalled int
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			prg := &contextProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled), ctx: ctx}
+
+			var got bool
+			prg.init = func(env Environment) error {
+				got = env.IsContainerInit()
+				return nil
+			}
+
+			// act
+			_, err := RunContext(context.Background(), prg, WithContainerInit(c.mode))
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("IsContainerInit, want: %v got: %v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+// +build !windows
+
+package svc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// daemonEnv holds the descriptor a daemon reports to its parent on.
+const daemonEnv = "SVC_DAEMON_FD"
+
+// daemonArgs returns the command line used to start the daemon. It's a variable so
+// tests can start a helper process instead of the test binary.
+var daemonArgs = func() []string {
+	return os.Args
+}
+
+// daemonize starts the program again as a daemon configured by d and waits for it
+// to report that it started. It returns nil once the daemon has started, or the
+// error it failed to start with.
+func daemonize(d *Daemon) error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	var files []*os.File
+	closeAll := func() error {
+		var err error
+		for _, f := range append(files, r, w) {
+			if closeErr := f.Close(); closeErr != nil && err == nil && !errors.Is(closeErr, os.ErrClosed) {
+				err = closeErr
+			}
+		}
+		return err
+	}
+
+	open := func(name string, flag int) (*os.File, error) {
+		if name == "" {
+			name = os.DevNull
+		}
+		f, err := os.OpenFile(name, flag, 0644)
+		if err == nil {
+			files = append(files, f)
+		}
+		return f, err
+	}
+
+	stdin, err := open("", os.O_RDONLY)
+	if err != nil {
+		return daemonFailed(err, closeAll())
+	}
+	stdout, err := open(d.Stdout, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return daemonFailed(err, closeAll())
+	}
+	stderr, err := open(d.Stderr, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return daemonFailed(err, closeAll())
+	}
+
+	dir := d.Dir
+	if dir == "" {
+		dir = "/"
+	}
+
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   daemonArgs(),
+		Env:    append(os.Environ(), daemonEnv+"=3"),
+		Dir:    dir,
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		// the pipe's write end is fd 3 in the daemon
+		ExtraFiles:  []*os.File{w},
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	if err := cmd.Start(); err != nil {
+		return daemonFailed(err, closeAll())
+	}
+
+	// close our copy of the write end so reading fails if the daemon dies
+	if err := w.Close(); err != nil {
+		return daemonFailed(err, closeAll())
+	}
+
+	err = readReport(r, cmd, "daemon")
+	if closeErr := closeAll(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// readReport waits for the process started as cmd to report on r that it started,
+// returning nil, or the error it failed with. what names the process in errors.
+func readReport(r io.Reader, cmd *exec.Cmd, what string) error {
+	line, readErr := bufio.NewReader(r).ReadString('\n')
+	if line == "ok\n" {
+		return nil
+	}
+
+	// the process failed and is exiting
+	waitErr := cmd.Wait()
+	msg := strings.TrimSuffix(strings.TrimPrefix(line, "error: "), "\n")
+	if msg == "" {
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		msg = "exited before starting"
+	}
+	err := fmt.Errorf("svc: %s: %s", what, msg)
+
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) && exitErr.ExitCode() > 0 {
+		return &ExitError{Code: exitErr.ExitCode(), Err: err}
+	}
+	return err
+}
+
+func daemonFailed(err, closeErr error) error {
+	if closeErr != nil {
+		return fmt.Errorf("%w; closing files: %v", err, closeErr)
+	}
+	return err
+}
+
+// parentPipe is the write end of the pipe a process started by Run, as a daemon
+// or an upgrade, reports to its parent on.
+type parentPipe struct {
+	f *os.File
+}
+
+// daemonStarted returns the pipe to the parent process if this process is the
+// daemon started by WithDaemon, after setting the configured umask if any, or nil
+// otherwise.
+func daemonStarted(d *Daemon) (*parentPipe, error) {
+	p, err := inheritedPipe(daemonEnv)
+	if p != nil && d.Umask != nil {
+		syscall.Umask(*d.Umask)
+	}
+	return p, err
+}
+
+// inheritedPipe returns the pipe whose descriptor is in the environment variable
+// key, or nil if key isn't set. key is removed from the environment.
+func inheritedPipe(key string) (*parentPipe, error) {
+	s := os.Getenv(key)
+	if s == "" {
+		return nil, nil
+	}
+	if err := os.Unsetenv(key); err != nil {
+		return nil, err
+	}
+
+	fd, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("svc: invalid %s %q", key, s)
+	}
+	syscall.CloseOnExec(fd)
+
+	return &parentPipe{f: os.NewFile(uintptr(fd), key)}, nil
+}
+
+// report tells the parent process this process started, or failed to start with
+// err, and closes the pipe. Only the first report is sent.
+func (c *parentPipe) report(err error) error {
+	if c == nil || c.f == nil {
+		return nil
+	}
+
+	msg := "ok\n"
+	if err != nil {
+		msg = "error: " + strings.Replace(err.Error(), "\n", " ", -1) + "\n"
+	}
+	_, writeErr := io.WriteString(c.f, msg)
+	if closeErr := c.f.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	c.f = nil
+	return writeErr
+}
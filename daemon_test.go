@@ -0,0 +1,180 @@
+// +build !windows
+
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestDaemonHelperProcess is the daemon started by the daemon tests.
+func TestDaemonHelperProcess(t *testing.T) {
+	mode := os.Getenv("GO_SVC_DAEMON_HELPER")
+	if mode == "" {
+		return
+	}
+
+	var startCalled, stopCalled, initCalled int
+	ctx, cancel := context.WithCancel(context.Background())
+	prg := &contextProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled), ctx: ctx}
+	prg.init = func(Environment) error {
+		if mode == "fail" {
+			return &ExitError{Code: ExitConfig, Err: errors.New("bad config")}
+		}
+		return nil
+	}
+	prg.start = func() error {
+		sid, err := unix.Getsid(0)
+		if err != nil {
+			return err
+		}
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		umask := syscall.Umask(0)
+		fmt.Printf("session leader: %v\numask: %o\ndir: %s\n", sid == os.Getpid(), umask, dir)
+		cancel()
+		return nil
+	}
+
+	// the same configuration as the parent; only Umask applies in the daemon
+	var d Daemon
+	if mode != "inherit" {
+		d.Umask = intPtr(027)
+	}
+	Main(prg, WithDaemon(d))
+}
+
+func runDaemon(t *testing.T, mode string, d Daemon) (int, error) {
+	daemonArgs = func() []string {
+		return []string{os.Args[0], "-test.run=^TestDaemonHelperProcess$"}
+	}
+	defer func() {
+		daemonArgs = func() []string {
+			return os.Args
+		}
+	}()
+	setenv(t, "GO_SVC_DAEMON_HELPER", mode)
+
+	exitCode := -1
+	osExit = func(code int) {
+		exitCode = code
+	}
+	defer func() {
+		osExit = os.Exit
+	}()
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	_, err := RunContext(context.Background(), prg, WithDaemon(d))
+	if initCalled != 0 {
+		t.Errorf("initCalled in parent, want: 0 got: %d", initCalled)
+	}
+	return exitCode, err
+}
+
+func TestRunContextDaemon(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	stdout := filepath.Join(dir, "stdout.log")
+
+	// act
+	exitCode, err := runDaemon(t, "ok", Daemon{Stdout: stdout, Umask: intPtr(027), Dir: dir})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != ExitOK {
+		t.Errorf("exit code, want: %d got: %d", ExitOK, exitCode)
+	}
+
+	b, err := ioutil.ReadFile(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"session leader: true\n", "umask: 27\n", "dir: " + realDir + "\n"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("daemon output doesn't contain %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestRunContextDaemonInheritUmask(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	stdout := filepath.Join(dir, "stdout.log")
+	prev := syscall.Umask(077)
+	defer syscall.Umask(prev)
+
+	// act
+	_, err := runDaemon(t, "inherit", Daemon{Stdout: stdout, Dir: dir})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "umask: 77\n") {
+		t.Errorf("daemon output doesn't contain the inherited umask:\n%s", b)
+	}
+}
+
+func TestRunContextDaemonListenFdsWithoutPID(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	stdout := filepath.Join(dir, "stdout.log")
+	// fd 3 in the daemon is the pipe it reports on, not a socket
+	setenv(t, "LISTEN_FDS", "1")
+	setenv(t, "LISTEN_PID", "")
+
+	// act
+	exitCode, err := runDaemon(t, "ok", Daemon{Stdout: stdout, Dir: dir})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != ExitOK {
+		t.Errorf("exit code, want: %d got: %d", ExitOK, exitCode)
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestRunContextDaemonFailed(t *testing.T) {
+	// act
+	exitCode, err := runDaemon(t, "fail", Daemon{Dir: t.TempDir()})
+
+	// assert
+	if exitCode != -1 {
+		t.Errorf("parent exited with %d", exitCode)
+	}
+	if want := "svc: daemon: bad config"; err == nil || err.Error() != want {
+		t.Errorf("RunContext, want: %q got: %v", want, err)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != ExitConfig {
+		t.Errorf("ExitError, want code: %d got: %v", ExitConfig, err)
+	}
+}
@@ -0,0 +1,107 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Exit codes used by DefaultExitCode, from BSD's sysexits.h.
+const (
+	// ExitOK means the service stopped without error.
+	ExitOK = 0
+	// ExitUsage means the program was invoked incorrectly, for example with bad flags.
+	ExitUsage = 64
+	// ExitDataErr means the input data was incorrect.
+	ExitDataErr = 65
+	// ExitUnavailable means a service the program needs is unavailable.
+	ExitUnavailable = 69
+	// ExitSoftware means an internal software error was detected.
+	ExitSoftware = 70
+	// ExitOSErr means an operating system error was detected, for example failing to fork.
+	ExitOSErr = 71
+	// ExitCantCreate means an output file couldn't be created.
+	ExitCantCreate = 73
+	// ExitIOErr means an error occurred doing I/O.
+	ExitIOErr = 74
+	// ExitTempFail means a temporary failure; running the program again may succeed.
+	ExitTempFail = 75
+	// ExitNoPerm means the program lacks the permissions it needs.
+	ExitNoPerm = 77
+	// ExitConfig means the program is misconfigured.
+	ExitConfig = 78
+)
+
+// An ExitError is an error carrying the exit code the process should exit with.
+// A Service returns an ExitError, or an error wrapping one, from a lifecycle method
+// or passes one to the Environment's Fail method to choose the exit code used by
+// DefaultExitCode.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("svc: exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultExitCode is the default exit code policy. It returns ExitOK when err is
+// nil, the code of the first *ExitError in err's chain, ExitSoftware for a
+// *PanicError, ExitTempFail when another instance is already running (see
+// ErrAlreadyRunning), ExitConfig when Run failed before or during Init,
+// ExitUnavailable when Start failed, and ExitSoftware for any other failure.
+func DefaultExitCode(res Result, err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return ExitSoftware
+	}
+	if errors.Is(err, ErrAlreadyRunning) {
+		return ExitTempFail
+	}
+
+	switch res.Phase {
+	case 0, PhaseInit:
+		return ExitConfig
+	case PhaseStart:
+		return ExitUnavailable
+	default:
+		return ExitSoftware
+	}
+}
+
+// Main runs service with RunContext and exits the process with the exit code chosen
+// by the exit code policy, see WithExitCodes, logging the error first if there is
+// one. Main is intended to be the last call in a program's main function:
+//
+//	func main() {
+//		svc.Main(&program{}, svc.WithName("awesome"))
+//	}
+func Main(service Service, opts ...Option) {
+	res, err := RunContext(context.Background(), service, opts...)
+
+	o, optsErr := newOptions(opts)
+	if optsErr != nil {
+		// RunContext failed with the same error
+		o = &options{logger: stdLogger{}, exitCode: DefaultExitCode}
+	}
+
+	if err != nil {
+		o.logger.Printf("%v", err)
+	}
+	osExit(o.exitCode(res, err))
+}
@@ -0,0 +1,95 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDefaultExitCode(t *testing.T) {
+	cases := []struct {
+		name  string
+		phase Phase
+		err   error
+		want  int
+	}{
+		{name: "ok", phase: PhaseStop, want: ExitOK},
+		{name: "init", phase: PhaseInit, err: errors.New("bad config"), want: ExitConfig},
+		{name: "before init", err: errors.New("bad option"), want: ExitConfig},
+		{name: "start", phase: PhaseStart, err: errors.New("db down"), want: ExitUnavailable},
+		{name: "stop", phase: PhaseStop, err: errors.New("flush failed"), want: ExitSoftware},
+		{name: "panic", phase: PhaseInit, err: &PanicError{Phase: PhaseInit, Value: "boom"}, want: ExitSoftware},
+		{name: "exit error", phase: PhaseStart, err: fmt.Errorf("wrapped: %w", &ExitError{Code: ExitNoPerm}), want: ExitNoPerm},
+		{name: "pidfile locked", err: &PIDFileError{Path: "svc.pid", PID: 42}, want: ExitTempFail},
+		{name: "instance running", err: &InstanceError{Name: "svc"}, want: ExitTempFail},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := DefaultExitCode(Result{Phase: c.phase}, c.err); got != c.want {
+				t.Errorf("DefaultExitCode, want: %d got: %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestMainExitCode(t *testing.T) {
+	cases := []struct {
+		name string
+		init error
+		opts []Option
+		want int
+	}{
+		{name: "ok", want: ExitOK},
+		{name: "init error", init: errors.New("bad config"), want: ExitConfig},
+		{name: "exit error", init: &ExitError{Code: ExitUsage, Err: errors.New("unknown flag")}, want: ExitUsage},
+		{
+			name: "policy",
+			init: errors.New("bad config"),
+			opts: []Option{WithExitCodes(func(res Result, err error) int {
+				if err != nil {
+					return 3
+				}
+				return 0
+			})},
+			want: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var startCalled, stopCalled, initCalled int
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			prg := &contextProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled), ctx: ctx}
+			prg.init = func(Environment) error {
+				return c.init
+			}
+
+			exitCode := -1
+			osExit = func(code int) {
+				exitCode = code
+			}
+			defer func() {
+				osExit = os.Exit
+			}()
+
+			logger := &recordingLogger{}
+			opts := append([]Option{WithLogger(logger)}, c.opts...)
+
+			// act
+			Main(prg, opts...)
+
+			// assert
+			if exitCode != c.want {
+				t.Errorf("exit code, want: %d got: %d", c.want, exitCode)
+			}
+			if c.init != nil && (len(logger.messages) != 1 || logger.messages[0] != c.init.Error()) {
+				t.Errorf("messages, want: %q got: %q", c.init.Error(), logger.messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package svc
+
+import "sync"
+
+// failures implements the Environment's Fail and Go methods, keeping the first
+// error reported.
+type failures struct {
+	c  chan error
+	wg sync.WaitGroup
+}
+
+func newFailures() *failures {
+	return &failures{c: make(chan error, 1)}
+}
+
+func (f *failures) Fail(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case f.c <- err:
+	default:
+	}
+}
+
+func (f *failures) Go(fn func() error) {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.Fail(fn())
+	}()
+}
+
+// failed delivers the first error reported.
+func (f *failures) failed() <-chan error {
+	return f.c
+}
+
+// wait waits for the functions started with Go to return.
+func (f *failures) wait() {
+	f.wg.Wait()
+}
@@ -0,0 +1,76 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunContextFail(t *testing.T) {
+	// arrange
+	failErr := errors.New("queue consumer died")
+	var env Environment
+	stopped := make(chan struct{})
+	goReturned := false
+
+	prg := &mockProgram{
+		init: func(e Environment) error {
+			env = e
+			return nil
+		},
+		start: func() error {
+			env.Go(func() error {
+				<-stopped
+				goReturned = true
+				return nil
+			})
+			env.Go(func() error {
+				return failErr
+			})
+			return nil
+		},
+		stop: func() error {
+			close(stopped)
+			return nil
+		},
+	}
+
+	// act
+	res, err := RunContext(context.Background(), prg)
+
+	// assert
+	if !errors.Is(err, failErr) {
+		t.Errorf("RunContext, want: %v got: %v", failErr, err)
+	}
+	if res.Cause != CauseFailure {
+		t.Errorf("Cause, want: %v got: %v", CauseFailure, res.Cause)
+	}
+	if res.Phase != PhaseStop {
+		t.Errorf("Phase, want: %v got: %v", PhaseStop, res.Phase)
+	}
+	if !goReturned {
+		t.Error("RunContext returned before the functions started with Go")
+	}
+}
+
+func TestFailuresFirstError(t *testing.T) {
+	f := newFailures()
+
+	f.Fail(nil)
+	f.Fail(errors.New("first"))
+	f.Fail(errors.New("second"))
+
+	select {
+	case err := <-f.failed():
+		if err.Error() != "first" {
+			t.Errorf("failed, want: first got: %v", err)
+		}
+	default:
+		t.Fatal("no failure reported")
+	}
+	select {
+	case err := <-f.failed():
+		t.Errorf("failed, want only the first error, got: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,386 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A Group runs several Services as one Service.
+//
+// Members are started once the members they depend on have started, with
+// independent members starting in parallel, and stopped once the members which
+// depend on them have stopped. Members added with Add depend on every member
+// added before them, so a Group built only with Add starts its members in the
+// order they were added and stops them in reverse order. Use AddDependent to
+// declare dependencies explicitly.
+//
+// Init initializes the members one at a time in dependency order, after checking
+// the dependencies are valid. If a member fails to start, no more members are
+// started, the members already started are stopped again and Start returns the
+// error. Stop stops every started member even if some fail, returning all their
+// errors as a MultiError.
+//
+// Members implementing ContextStarter or ContextStopper are passed the context
+// given to the Group's StartContext and StopContext methods, which Run calls with
+// the start and stop deadlines.
+//
+// The zero value is an empty Group ready to use.
+type Group struct {
+	members []*groupMember
+	// order is members sorted so each member comes after its dependencies
+	order []*groupMember
+}
+
+type groupMember struct {
+	name      string
+	service   Service
+	ordered   bool
+	dependsOn []string
+	deps      []*groupMember
+	started   bool
+}
+
+// Add adds service to the Group, to be started after every member added before
+// it. name identifies the member in errors and to AddDependent, and must be unique.
+// Add must not be called once the Group has been passed to Run.
+func (g *Group) Add(name string, service Service) {
+	g.members = append(g.members, &groupMember{name: name, service: service, ordered: true})
+}
+
+// AddDependent adds service to the Group, to be started after the members named
+// in dependsOn, which may be added before or after it. With no dependencies the
+// member starts as soon as the Group is started. name identifies the member in
+// errors and must be unique.
+//
+// For example, to start db and metrics in parallel and cache once db has started:
+//
+//	g.AddDependent("db", db)
+//	g.AddDependent("cache", cache, "db")
+//	g.AddDependent("metrics", metrics)
+//
+// AddDependent must not be called once the Group has been passed to Run.
+func (g *Group) AddDependent(name string, service Service, dependsOn ...string) {
+	g.members = append(g.members, &groupMember{name: name, service: service, dependsOn: dependsOn})
+}
+
+// Init resolves the members' dependencies, returning an error for unknown
+// members and dependency cycles, then calls Init on each member in dependency
+// order, stopping at the first error.
+func (g *Group) Init(env Environment) error {
+	if err := g.resolve(); err != nil {
+		return err
+	}
+
+	for _, m := range g.order {
+		if err := m.service.Init(env); err != nil {
+			return &MemberError{Name: m.name, Phase: PhaseInit, Err: err}
+		}
+	}
+	return nil
+}
+
+// resolve links each member to its dependencies and sorts the members into g.order.
+func (g *Group) resolve() error {
+	byName := make(map[string]*groupMember, len(g.members))
+	for i, m := range g.members {
+		if _, ok := byName[m.name]; ok {
+			return fmt.Errorf("svc: group: duplicate member %q", m.name)
+		}
+		byName[m.name] = m
+
+		if m.ordered {
+			m.deps = g.members[:i:i]
+			continue
+		}
+
+		m.deps = nil
+		for _, name := range m.dependsOn {
+			dep, ok := byName[name]
+			if !ok {
+				dep = g.member(name)
+			}
+			if dep == nil {
+				return fmt.Errorf("svc: group: %q depends on unknown member %q", m.name, name)
+			}
+			m.deps = append(m.deps, dep)
+		}
+	}
+
+	// depth-first search, appending members after their dependencies
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*groupMember]int, len(g.members))
+	order := make([]*groupMember, 0, len(g.members))
+	var path []*groupMember
+
+	var visit func(m *groupMember) error
+	visit = func(m *groupMember) error {
+		switch state[m] {
+		case visited:
+			return nil
+		case visiting:
+			var names []string
+			for i := len(path) - 1; i >= 0; i-- {
+				names = append(names, path[i].name)
+				if path[i] == m {
+					break
+				}
+			}
+			// names runs from m back to m's dependent, reverse it to follow the edges
+			for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+				names[i], names[j] = names[j], names[i]
+			}
+			return fmt.Errorf("svc: group: dependency cycle: %s -> %s", strings.Join(names, " -> "), m.name)
+		}
+
+		state[m] = visiting
+		path = append(path, m)
+		for _, dep := range m.deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[m] = visited
+		order = append(order, m)
+		return nil
+	}
+
+	for _, m := range g.members {
+		if err := visit(m); err != nil {
+			return err
+		}
+	}
+
+	g.order = order
+	return nil
+}
+
+func (g *Group) member(name string) *groupMember {
+	for _, m := range g.members {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Start starts the members in dependency order.
+func (g *Group) Start() error {
+	return g.StartContext(context.Background())
+}
+
+// StartContext starts each member once its dependencies have started. If a member
+// fails to start no further members are started, and the members already started
+// are stopped in reverse dependency order.
+func (g *Group) StartContext(ctx context.Context) error {
+	order := g.startOrder()
+
+	ready := make(map[*groupMember]chan struct{}, len(order))
+	for _, m := range order {
+		ready[m] = make(chan struct{})
+	}
+
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	errs := make([]error, len(order))
+
+	var wg sync.WaitGroup
+	for i, m := range order {
+		wg.Add(1)
+		go func(i int, m *groupMember) {
+			defer wg.Done()
+
+			for _, dep := range m.deps {
+				select {
+				case <-ready[dep]:
+				case <-failed:
+					return
+				}
+			}
+
+			// don't start anything new once a member has failed
+			select {
+			case <-failed:
+				return
+			default:
+			}
+
+			if err := m.start(ctx); err != nil {
+				errs[i] = &MemberError{Name: m.name, Phase: PhaseStart, Err: err}
+				failOnce.Do(func() {
+					close(failed)
+				})
+				return
+			}
+			m.started = true
+			close(ready[m])
+		}(i, m)
+	}
+	wg.Wait()
+
+	var startErrs MultiError
+	for _, err := range errs {
+		if err != nil {
+			startErrs = append(startErrs, err)
+		}
+	}
+	if len(startErrs) == 0 {
+		return nil
+	}
+
+	return append(startErrs, g.stopStarted(ctx)...).err()
+}
+
+// startOrder returns the members in dependency order, resolving them if
+// the Group is started without being initialized first.
+func (g *Group) startOrder() []*groupMember {
+	if len(g.order) != len(g.members) {
+		if err := g.resolve(); err != nil {
+			// Init reports the error; start in the order added
+			return g.members
+		}
+	}
+	return g.order
+}
+
+// Stop stops the started members in reverse dependency order.
+func (g *Group) Stop() error {
+	return g.StopContext(context.Background())
+}
+
+// StopContext stops each started member once the members depending on it have
+// stopped. Every started member is stopped even if stopping another fails; the
+// errors are returned as a MultiError.
+func (g *Group) StopContext(ctx context.Context) error {
+	return g.stopStarted(ctx).err()
+}
+
+func (g *Group) stopStarted(ctx context.Context) MultiError {
+	order := g.startOrder()
+
+	// dependents[m] are the members which have to stop before m
+	dependents := make(map[*groupMember][]*groupMember, len(order))
+	stopped := make(map[*groupMember]chan struct{}, len(order))
+	for _, m := range order {
+		stopped[m] = make(chan struct{})
+		for _, dep := range m.deps {
+			dependents[dep] = append(dependents[dep], m)
+		}
+	}
+
+	errs := make([]error, len(order))
+
+	var wg sync.WaitGroup
+	for i, m := range order {
+		wg.Add(1)
+		go func(i int, m *groupMember) {
+			defer wg.Done()
+			defer close(stopped[m])
+
+			for _, d := range dependents[m] {
+				<-stopped[d]
+			}
+
+			if !m.started {
+				return
+			}
+			m.started = false
+			if err := m.stop(ctx); err != nil {
+				errs[i] = &MemberError{Name: m.name, Phase: PhaseStop, Err: err}
+			}
+		}(i, m)
+	}
+	wg.Wait()
+
+	// report errors in the order members were stopped
+	var stopErrs MultiError
+	for i := len(errs) - 1; i >= 0; i-- {
+		if errs[i] != nil {
+			stopErrs = append(stopErrs, errs[i])
+		}
+	}
+	return stopErrs
+}
+
+func (m *groupMember) start(ctx context.Context) error {
+	if s, ok := m.service.(ContextStarter); ok {
+		return s.StartContext(ctx)
+	}
+	return m.service.Start()
+}
+
+func (m *groupMember) stop(ctx context.Context) error {
+	if s, ok := m.service.(ContextStopper); ok {
+		return s.StopContext(ctx)
+	}
+	return m.service.Stop()
+}
+
+// A MemberError is an error returned by a member of a Group.
+type MemberError struct {
+	// Name is the name the member was added to the Group with.
+	Name string
+	// Phase is the lifecycle method which failed.
+	Phase Phase
+	// Err is the error returned by the member.
+	Err error
+}
+
+func (e *MemberError) Error() string {
+	return fmt.Sprintf("svc: %s: %s: %v", e.Name, e.Phase, e.Err)
+}
+
+func (e *MemberError) Unwrap() error {
+	return e.Err
+}
+
+// A MultiError is a list of errors returned together, for example by a Group
+// whose members failed to stop.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target.
+func (e MultiError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors which matches target.
+func (e MultiError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns nil if there are no errors, the error itself if there's only one,
+// and otherwise e.
+func (e MultiError) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
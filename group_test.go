@@ -0,0 +1,311 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingProgram returns a mockProgram which appends "<phase> <name>" to calls
+// for each lifecycle method, returning the error in errs for that phase, if any.
+func recordingProgram(name string, calls *[]string, errs map[Phase]error) *mockProgram {
+	return &mockProgram{
+		init: func(Environment) error {
+			*calls = append(*calls, "init "+name)
+			return errs[PhaseInit]
+		},
+		start: func() error {
+			*calls = append(*calls, "start "+name)
+			return errs[PhaseStart]
+		},
+		stop: func() error {
+			*calls = append(*calls, "stop "+name)
+			return errs[PhaseStop]
+		},
+	}
+}
+
+func TestGroup(t *testing.T) {
+	// arrange
+	var calls []string
+	var g Group
+	g.Add("db", recordingProgram("db", &calls, nil))
+	g.Add("cache", recordingProgram("cache", &calls, nil))
+	g.Add("http", recordingProgram("http", &calls, nil))
+
+	// act
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := RunContext(ctx, &g)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"init db", "init cache", "init http",
+		"start db", "start cache", "start http",
+		"stop http", "stop cache", "stop db",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("calls, want: %q got: %q", want, calls)
+	}
+}
+
+func TestGroupInitError(t *testing.T) {
+	// arrange
+	var calls []string
+	initErr := errors.New("bad config")
+
+	var g Group
+	g.Add("db", recordingProgram("db", &calls, nil))
+	g.Add("cache", recordingProgram("cache", &calls, map[Phase]error{PhaseInit: initErr}))
+	g.Add("http", recordingProgram("http", &calls, nil))
+
+	// act
+	err := g.Init(nil)
+
+	// assert
+	if !errors.Is(err, initErr) {
+		t.Errorf("Init, want: %v got: %v", initErr, err)
+	}
+	if got, want := err.Error(), "svc: cache: init: bad config"; got != want {
+		t.Errorf("Init error, want: %q got: %q", want, got)
+	}
+	if want := []string{"init db", "init cache"}; !reflect.DeepEqual(want, calls) {
+		t.Errorf("calls, want: %q got: %q", want, calls)
+	}
+}
+
+func TestGroupStartRollback(t *testing.T) {
+	// arrange
+	var calls []string
+	startErr := errors.New("address in use")
+	stopErr := errors.New("flush failed")
+
+	var g Group
+	g.Add("db", recordingProgram("db", &calls, map[Phase]error{PhaseStop: stopErr}))
+	g.Add("cache", recordingProgram("cache", &calls, nil))
+	g.Add("http", recordingProgram("http", &calls, map[Phase]error{PhaseStart: startErr}))
+	g.Add("metrics", recordingProgram("metrics", &calls, nil))
+
+	// act
+	err := g.Start()
+
+	// assert
+	want := []string{
+		"start db", "start cache", "start http",
+		"stop cache", "stop db",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("calls, want: %q got: %q", want, calls)
+	}
+
+	if !errors.Is(err, startErr) || !errors.Is(err, stopErr) {
+		t.Errorf("Start, want: %v and %v got: %v", startErr, stopErr, err)
+	}
+	if got, want := err.Error(), "svc: http: start: address in use; svc: db: stop: flush failed"; got != want {
+		t.Errorf("Start error, want: %q got: %q", want, got)
+	}
+
+	// nothing is left to stop
+	calls = nil
+	if err := g.Stop(); err != nil {
+		t.Errorf("Stop, want: <nil> got: %v", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("calls, want: [] got: %q", calls)
+	}
+}
+
+func TestGroupStopErrors(t *testing.T) {
+	// arrange
+	var calls []string
+	dbErr := errors.New("db error")
+	httpErr := errors.New("http error")
+
+	var g Group
+	g.Add("db", recordingProgram("db", &calls, map[Phase]error{PhaseStop: dbErr}))
+	g.Add("cache", recordingProgram("cache", &calls, nil))
+	g.Add("http", recordingProgram("http", &calls, map[Phase]error{PhaseStop: httpErr}))
+
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err := g.Stop()
+
+	// assert
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Stop, want: MultiError with 2 errors got: %v", err)
+	}
+
+	var memberErr *MemberError
+	if !errors.As(err, &memberErr) || memberErr.Name != "http" || memberErr.Phase != PhaseStop {
+		t.Errorf("first MemberError, want: http stop got: %v", memberErr)
+	}
+	if !errors.Is(err, dbErr) || !errors.Is(err, httpErr) {
+		t.Errorf("Stop, want: %v and %v got: %v", dbErr, httpErr, err)
+	}
+
+	want := []string{"start db", "start cache", "start http", "stop http", "stop cache", "stop db"}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("calls, want: %q got: %q", want, calls)
+	}
+}
+
+func TestGroupDependencies(t *testing.T) {
+	// arrange
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		calls = append(calls, call)
+		mu.Unlock()
+	}
+
+	// db and metrics are independent, so each waits for the other to start
+	dbStarted := make(chan struct{})
+	metricsStarted := make(chan struct{})
+	waitFor := func(c chan struct{}) error {
+		select {
+		case <-c:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("independent members not started in parallel")
+		}
+	}
+
+	member := func(name string, start func() error) *mockProgram {
+		return &mockProgram{
+			init: func(Environment) error {
+				return nil
+			},
+			start: func() error {
+				if start != nil {
+					if err := start(); err != nil {
+						return err
+					}
+				}
+				record("start " + name)
+				return nil
+			},
+			stop: func() error {
+				record("stop " + name)
+				return nil
+			},
+		}
+	}
+
+	var g Group
+	g.AddDependent("cache", member("cache", nil), "db")
+	g.AddDependent("db", member("db", func() error {
+		close(dbStarted)
+		return waitFor(metricsStarted)
+	}))
+	g.AddDependent("metrics", member("metrics", func() error {
+		close(metricsStarted)
+		return waitFor(dbStarted)
+	}))
+
+	// act
+	if err := g.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	startCalls := append([]string(nil), calls...)
+	calls = nil
+	if err := g.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	index := func(calls []string, call string) int {
+		for i, c := range calls {
+			if c == call {
+				return i
+			}
+		}
+		t.Fatalf("%q not called: %q", call, calls)
+		return -1
+	}
+	if index(startCalls, "start cache") < index(startCalls, "start db") {
+		t.Errorf("cache started before db: %q", startCalls)
+	}
+	if index(calls, "stop cache") > index(calls, "stop db") {
+		t.Errorf("db stopped before cache: %q", calls)
+	}
+	index(calls, "stop metrics")
+}
+
+func TestGroupDependencyErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		group func(g *Group)
+		want  string
+	}{
+		{
+			name: "cycle",
+			group: func(g *Group) {
+				g.AddDependent("a", &mockProgram{}, "c")
+				g.AddDependent("b", &mockProgram{}, "a")
+				g.AddDependent("c", &mockProgram{}, "b")
+			},
+			want: "svc: group: dependency cycle: a -> c -> b -> a",
+		},
+		{
+			name: "self",
+			group: func(g *Group) {
+				g.AddDependent("a", &mockProgram{}, "a")
+			},
+			want: "svc: group: dependency cycle: a -> a",
+		},
+		{
+			name: "unknown",
+			group: func(g *Group) {
+				g.AddDependent("cache", &mockProgram{}, "db")
+			},
+			want: `svc: group: "cache" depends on unknown member "db"`,
+		},
+		{
+			name: "duplicate",
+			group: func(g *Group) {
+				g.Add("db", &mockProgram{})
+				g.Add("db", &mockProgram{})
+			},
+			want: `svc: group: duplicate member "db"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			initCalled := false
+			var g Group
+			c.group(&g)
+			g.Add("last", &mockProgram{init: func(Environment) error {
+				initCalled = true
+				return nil
+			}})
+
+			// act
+			err := g.Init(nil)
+
+			// assert
+			if err == nil || err.Error() != c.want {
+				t.Errorf("Init, want: %q got: %v", c.want, err)
+			}
+			if initCalled {
+				t.Error("member Init called with invalid dependencies")
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package svc
+
+import (
+	"os"
+	"time"
+)
+
+// A Hook is a function run before or after the Start or Stop phase of a service
+// run by Run. phase is PhaseStart or PhaseStop.
+//
+// A hook run before a phase is passed the time spent in the phase preceding it,
+// Init before Start and running before Stop, and for BeforeStop the error which
+// stopped the service, if any (see Result.Err). A hook run after a phase is passed
+// the time the phase took and the error it returned.
+type Hook func(phase Phase, elapsed time.Duration, err error)
+
+// Hooks holds the functions run around the lifecycle phases of a service. Nil
+// hooks are skipped. See WithHooks.
+type Hooks struct {
+	// BeforeStart is run once Init has returned successfully.
+	BeforeStart Hook
+	// AfterStart is run when Start returns, whether or not it failed.
+	AfterStart Hook
+	// BeforeStop is run when the running service is about to be stopped.
+	BeforeStop Hook
+	// AfterStop is run when Stop returns or times out.
+	AfterStop Hook
+}
+
+// WithHooks adds hooks run around the Service's Start and Stop methods, for
+// cross-cutting concerns such as logging and metrics. Hooks are run on the
+// goroutine calling the Service's methods, in the order they were added, and
+// under the Windows SCM as well as on the command line.
+func WithHooks(h Hooks) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, h)
+	}
+}
+
+// WithOnReady adds a function to call once the service's Start method has
+// returned successfully. It's an AfterStart hook (see WithHooks) which is skipped
+// when Start fails.
+func WithOnReady(fn func()) Option {
+	return WithHooks(Hooks{
+		AfterStart: func(_ Phase, _ time.Duration, err error) {
+			if err == nil {
+				fn()
+			}
+		},
+	})
+}
+
+// runHooks calls the hook selected by which from each of the configured Hooks.
+func (o *options) runHooks(which func(Hooks) Hook, phase Phase, elapsed time.Duration, err error) {
+	for _, h := range o.hooks {
+		if hook := which(h); hook != nil {
+			hook(phase, elapsed, err)
+		}
+	}
+}
+
+func beforeStart(h Hooks) Hook { return h.BeforeStart }
+func afterStart(h Hooks) Hook  { return h.AfterStart }
+func beforeStop(h Hooks) Hook  { return h.BeforeStop }
+func afterStop(h Hooks) Hook   { return h.AfterStop }
+
+// runStart starts the service, recording how long Start took in res, and runs
+// the hooks around it.
+func runStart(service Service, o *options, res *Result) error {
+	o.runHooks(beforeStart, PhaseStart, res.InitDuration, nil)
+	err := timed(&res.StartDuration, func() error { return startService(service, o) })
+	o.runHooks(afterStart, PhaseStart, res.StartDuration, err)
+	return err
+}
+
+// runStop stops the service, recording how long Stop took and its error in res,
+// and runs the hooks around it. See stopService for f, signals and actions.
+func runStop(service Service, o *options, res *Result, f *failures, signals <-chan os.Signal, actions map[os.Signal]SignalAction) {
+	o.runHooks(beforeStop, PhaseStop, res.RunDuration, res.Err)
+	res.StopErr = timed(&res.StopDuration, func() error {
+		return stopService(service, o, f, signals, actions)
+	})
+	o.runHooks(afterStop, PhaseStop, res.StopDuration, res.StopErr)
+}
@@ -0,0 +1,92 @@
+// +build !windows
+
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordHooks returns Hooks appending a line per call to calls.
+func recordHooks(calls *[]string) Hooks {
+	record := func(name string) Hook {
+		return func(phase Phase, elapsed time.Duration, err error) {
+			if elapsed < 0 {
+				*calls = append(*calls, fmt.Sprintf("%s: negative elapsed time %v", name, elapsed))
+			}
+			*calls = append(*calls, fmt.Sprintf("%s %v %v", name, phase, err))
+		}
+	}
+	return Hooks{
+		BeforeStart: record("before start"),
+		AfterStart:  record("after start"),
+		BeforeStop:  record("before stop"),
+		AfterStop:   record("after stop"),
+	}
+}
+
+func TestRunContextHooks(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.stop = func() error {
+		return errors.New("stop failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls []string
+
+	// act
+	_, err := RunContext(ctx, prg, WithHooks(recordHooks(&calls)), WithOnReady(cancel))
+
+	// assert
+	if err == nil || err.Error() != "stop failed" {
+		t.Errorf("RunContext, want: stop failed got: %v", err)
+	}
+	want := []string{
+		"before start start <nil>",
+		"after start start <nil>",
+		"before stop stop context canceled",
+		"after stop stop stop failed",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("hooks, want: %q got: %q", want, calls)
+	}
+}
+
+func TestRunContextHooksStartError(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.start = func() error {
+		return errors.New("start failed")
+	}
+
+	var calls, more []string
+	empty := Hooks{}
+	ready := func() {
+		calls = append(calls, "ready")
+	}
+
+	// act
+	_, err := RunContext(context.Background(), prg, WithHooks(recordHooks(&calls)), WithHooks(empty), WithHooks(recordHooks(&more)), WithOnReady(ready))
+
+	// assert
+	if err == nil {
+		t.Fatal("RunContext, want: error got: <nil>")
+	}
+	want := []string{
+		"before start start <nil>",
+		"after start start start failed",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("hooks, want: %q got: %q", want, calls)
+	}
+	if !reflect.DeepEqual(want, more) {
+		t.Errorf("second hooks, want: %q got: %q", want, more)
+	}
+}
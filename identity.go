@@ -0,0 +1,9 @@
+package svc
+
+// An Identity is the user and groups a process runs as. On Windows UID and GID
+// are -1 and Groups is empty.
+type Identity struct {
+	UID    int
+	GID    int
+	Groups []int
+}
@@ -0,0 +1,96 @@
+// +build !windows
+
+package svc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// currentIdentity returns the effective identity of the process. Groups is nil if
+// the supplementary groups can't be read.
+func currentIdentity() Identity {
+	groups, err := os.Getgroups()
+	if err != nil {
+		groups = nil
+	}
+	return Identity{UID: os.Geteuid(), GID: os.Getegid(), Groups: groups}
+}
+
+// lookupIdentity returns the identity of the named user and group, each of which
+// may be a numeric ID. If group is "" the user's primary group is used.
+func lookupIdentity(username, group string) (Identity, error) {
+	u, err := user.Lookup(username)
+	if _, ok := err.(user.UnknownUserError); ok {
+		u, err = user.LookupId(username)
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("svc: looking up user %q: %w", username, err)
+	}
+
+	id := Identity{}
+	if id.UID, err = strconv.Atoi(u.Uid); err != nil {
+		return Identity{}, fmt.Errorf("svc: user %q has non-numeric uid %q", username, u.Uid)
+	}
+
+	gid := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if _, ok := err.(user.UnknownGroupError); ok {
+			g, err = user.LookupGroupId(group)
+		}
+		if err != nil {
+			return Identity{}, fmt.Errorf("svc: looking up group %q: %w", group, err)
+		}
+		gid = g.Gid
+	}
+	if id.GID, err = strconv.Atoi(gid); err != nil {
+		return Identity{}, fmt.Errorf("svc: group %q has non-numeric gid %q", group, gid)
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return Identity{}, fmt.Errorf("svc: looking up groups of user %q: %w", username, err)
+	}
+	for _, s := range groupIDs {
+		g, err := strconv.Atoi(s)
+		if err != nil {
+			return Identity{}, fmt.Errorf("svc: user %q has non-numeric group id %q", username, s)
+		}
+		id.Groups = append(id.Groups, g)
+	}
+	return id, nil
+}
+
+// switchUser switches every thread of the process to id, supplementary groups
+// first since changing them needs privileges the process is about to give up.
+func switchUser(id Identity) error {
+	if err := syscall.Setgroups(id.Groups); err != nil {
+		return setidError("setgroups", err)
+	}
+	if err := syscall.Setgid(id.GID); err != nil {
+		return setidError("setgid", err)
+	}
+	if err := syscall.Setuid(id.UID); err != nil {
+		return setidError("setuid", err)
+	}
+
+	if id.UID != 0 {
+		if err := syscall.Setuid(0); err == nil {
+			return errors.New("svc: root privileges could be regained after switching user")
+		}
+	}
+	return nil
+}
+
+func setidError(call string, err error) error {
+	if err == syscall.EOPNOTSUPP {
+		// Go before 1.16 can't change the credentials of every thread on Linux
+		return fmt.Errorf("svc: %s isn't supported for all threads by this Go version, build with Go 1.16 or later: %w", call, err)
+	}
+	return fmt.Errorf("svc: %s: %w", call, err)
+}
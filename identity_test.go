@@ -0,0 +1,108 @@
+// +build !windows
+
+package svc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestUserHelperProcess runs a service as the user named by GO_SVC_USER_HELPER,
+// printing its identity and that of every thread once started.
+func TestUserHelperProcess(t *testing.T) {
+	name := os.Getenv("GO_SVC_USER_HELPER")
+	if name == "" {
+		return
+	}
+
+	var startCalled, stopCalled, initCalled int
+	ctx, cancel := context.WithCancel(context.Background())
+	prg := &contextProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled), ctx: ctx}
+
+	var env Environment
+	prg.init = func(e Environment) error {
+		env = e
+		return nil
+	}
+	prg.start = func() error {
+		id := env.Identity()
+		fmt.Printf("identity: %d %d %v\n", id.UID, id.GID, id.Groups)
+
+		if runtime.GOOS == "linux" {
+			tasks, err := filepath.Glob("/proc/self/task/*/status")
+			if err != nil {
+				return err
+			}
+			for _, task := range tasks {
+				b, err := ioutil.ReadFile(task)
+				if err != nil {
+					return err
+				}
+				for _, line := range strings.Split(string(b), "\n") {
+					if strings.HasPrefix(line, "Uid:") {
+						fmt.Printf("thread %s\n", strings.Join(strings.Fields(line), " "))
+					}
+				}
+			}
+		}
+		cancel()
+		return nil
+	}
+
+	Main(prg, WithUser(name, ""))
+}
+
+func TestRunContextUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching user needs root")
+	}
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user:", err)
+	}
+
+	// act
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUserHelperProcess$")
+	cmd.Env = append(os.Environ(), "GO_SVC_USER_HELPER=nobody")
+	out, err := cmd.CombinedOutput()
+
+	// assert
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	want := fmt.Sprintf("identity: %s %s ", u.Uid, u.Gid)
+	if !strings.Contains(string(out), want) {
+		t.Errorf("output doesn't contain %q:\n%s", want, out)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "thread ") {
+			continue
+		}
+		if want := fmt.Sprintf("thread Uid: %[1]s %[1]s %[1]s %[1]s", u.Uid); line != want {
+			t.Errorf("thread identity, want: %q got: %q", want, line)
+		}
+	}
+}
+
+func TestLookupIdentity(t *testing.T) {
+	id, err := lookupIdentity("0", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.UID != 0 || id.GID != 0 {
+		t.Errorf("lookupIdentity, want: 0/0 got: %d/%d", id.UID, id.GID)
+	}
+
+	if _, err := lookupIdentity("go-svc-no-such-user", ""); err == nil {
+		t.Error("lookupIdentity of an unknown user succeeded")
+	}
+}
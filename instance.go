@@ -0,0 +1,36 @@
+package svc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An InstanceError is returned by Run when another instance holds the name passed
+// to WithSingleInstance or WithSingleUserInstance. It matches ErrAlreadyRunning
+// using errors.Is.
+type InstanceError struct {
+	Name string
+	// Reloaded reports whether the running instance accepted a request to reload.
+	// See WithReloadRunningInstance.
+	Reloaded bool
+}
+
+func (e *InstanceError) Error() string {
+	if e.Reloaded {
+		return fmt.Sprintf("svc: instance %q already running, asked it to reload", e.Name)
+	}
+	return fmt.Sprintf("svc: instance %q already running", e.Name)
+}
+
+// Is reports whether target is ErrAlreadyRunning.
+func (e *InstanceError) Is(target error) bool {
+	return target == ErrAlreadyRunning
+}
+
+// checkInstanceName returns an error if name can't be used as an instance name.
+func checkInstanceName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`+"\x00") {
+		return fmt.Errorf("svc: invalid instance name %q", name)
+	}
+	return nil
+}
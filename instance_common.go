@@ -0,0 +1,152 @@
+// +build !windows
+
+package svc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errInstanceRunning is returned by listenInstance when another process holds the name.
+var errInstanceRunning = errors.New("svc: instance running")
+
+// instance holds the instance name for this process, and serves reload requests
+// from processes which find it running.
+type instance struct {
+	ln      *net.UnixListener
+	lock    *os.File
+	reloads chan struct{}
+	o       *options
+	wg      sync.WaitGroup
+}
+
+// acquireInstance takes the instance name configured with WithSingleInstance or
+// WithSingleUserInstance.
+func acquireInstance(o *options) (*instance, error) {
+	if err := checkInstanceName(o.instance); err != nil {
+		return nil, err
+	}
+
+	name := "svc." + o.instance
+	if o.perUser {
+		name += "." + strconv.Itoa(os.Getuid())
+	}
+
+	ln, lock, addr, err := listenInstance(name)
+	if err == errInstanceRunning {
+		instErr := &InstanceError{Name: o.instance}
+		if o.reloadRunning {
+			if err := requestReload(addr); err != nil {
+				o.logf("asking the running instance to reload: %v", err)
+			} else {
+				instErr.Reloaded = true
+			}
+		}
+		return nil, instErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &instance{ln: ln, lock: lock, reloads: make(chan struct{}, 1), o: o}
+	inst.wg.Add(1)
+	go inst.serve()
+	return inst, nil
+}
+
+// reloadRequests delivers the reload requests from other instances. It's nil when
+// inst is nil, so a select never receives from it.
+func (inst *instance) reloadRequests() <-chan struct{} {
+	if inst == nil {
+		return nil
+	}
+	return inst.reloads
+}
+
+// serve accepts reload requests until the listener is closed.
+func (inst *instance) serve() {
+	defer inst.wg.Done()
+	for {
+		conn, err := inst.ln.AcceptUnix()
+		if err != nil {
+			return
+		}
+
+		err = inst.handle(conn)
+		if closeErr := conn.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			inst.o.logf("reload request: %v", err)
+		}
+	}
+}
+
+// handle reads a single request from conn and answers it.
+func (inst *instance) handle(conn *net.UnixConn) error {
+	if err := peerAllowed(conn); err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != "reload\n" {
+		return fmt.Errorf("unknown request %q", line)
+	}
+
+	select {
+	case inst.reloads <- struct{}{}:
+	default:
+		// a reload is already pending
+	}
+
+	_, err = conn.Write([]byte("ok\n"))
+	return err
+}
+
+// release stops serving reload requests and releases the instance name.
+func (inst *instance) release() error {
+	err := inst.ln.Close()
+	inst.wg.Wait()
+	if inst.lock != nil {
+		if closeErr := inst.lock.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// requestReload asks the instance listening on addr to reload.
+func requestReload(addr string) error {
+	conn, err := net.DialTimeout("unix", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err == nil {
+		_, err = conn.Write([]byte("reload\n"))
+	}
+	var reply string
+	if err == nil {
+		reply, err = bufio.NewReader(conn).ReadString('\n')
+	}
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && reply != "ok\n" {
+		err = errors.New("svc: unexpected reply to reload request")
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+// +build linux
+
+package svc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenInstance listens on the abstract unix socket for name. The kernel releases
+// the name when the socket is closed, including when the process dies.
+func listenInstance(name string) (*net.UnixListener, *os.File, string, error) {
+	addr := "@" + name
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return nil, nil, addr, errInstanceRunning
+	}
+	if err != nil {
+		return nil, nil, addr, err
+	}
+	return ln, nil, addr, nil
+}
+
+// peerAllowed returns an error unless the process at the other end of conn is run
+// by the same user as this one, or by root. Abstract sockets have no file permissions.
+func peerAllowed(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+
+	if cred.Uid != 0 && int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("refused request from uid %d", cred.Uid)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// +build !windows,!linux
+
+package svc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// listenInstance locks the file for name in os.TempDir(), then listens on a unix
+// socket next to it, replacing the socket left by a previous holder. The lock is
+// released when the file is closed, including when the process dies.
+func listenInstance(name string) (*net.UnixListener, *os.File, string, error) {
+	base := filepath.Join(os.TempDir(), name)
+	addr := base + ".sock"
+
+	lock, err := os.OpenFile(base+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, addr, err
+	}
+
+	fail := func(err error) (*net.UnixListener, *os.File, string, error) {
+		if closeErr := lock.Close(); closeErr != nil {
+			return nil, nil, addr, closeErr
+		}
+		return nil, nil, addr, err
+	}
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return fail(errInstanceRunning)
+		}
+		return fail(err)
+	}
+
+	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		return fail(err)
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		return fail(err)
+	}
+	// only the owner, and root, can ask for a reload
+	if err := os.Chmod(addr, 0600); err != nil {
+		if closeErr := ln.Close(); closeErr != nil {
+			return fail(closeErr)
+		}
+		return fail(err)
+	}
+	return ln, lock, addr, nil
+}
+
+// peerAllowed returns nil; the socket's file permissions limit who can connect.
+func peerAllowed(*net.UnixConn) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+// +build !windows
+
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func testInstanceName(t *testing.T) string {
+	return fmt.Sprintf("go-svc-test-%d-%s", os.Getpid(), t.Name())
+}
+
+func TestRunContextSingleInstance(t *testing.T) {
+	// arrange
+	name := testInstanceName(t)
+	held, err := acquireInstance(testOptions(t, WithSingleInstance(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := held.release(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	// act
+	_, err = RunContext(context.Background(), prg, WithSingleInstance(name))
+
+	// assert
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("RunContext, want: %v got: %v", ErrAlreadyRunning, err)
+	}
+	if want := fmt.Sprintf("svc: instance %q already running", name); err.Error() != want {
+		t.Errorf("error, want: %q got: %q", want, err.Error())
+	}
+	if initCalled != 0 {
+		t.Errorf("initCalled, want: 0 got: %d", initCalled)
+	}
+}
+
+func TestRunContextSingleInstanceReleased(t *testing.T) {
+	// arrange
+	name := testInstanceName(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i := 0; i < 2; i++ {
+		var startCalled, stopCalled, initCalled int
+		prg := &contextProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled), ctx: ctx}
+
+		// act
+		_, err := RunContext(context.Background(), prg, WithSingleUserInstance(name))
+
+		// assert
+		if err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestRunContextReloadRunningInstance(t *testing.T) {
+	// arrange
+	name := testInstanceName(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var startCalled, stopCalled, initCalled int
+	instErr := make(chan error, 1)
+	prg := &reloadProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled)}
+	prg.start = func() error {
+		go func() {
+			_, err := acquireInstance(testOptions(t, WithSingleInstance(name), WithReloadRunningInstance()))
+			instErr <- err
+		}()
+		return nil
+	}
+	prg.reload = func() error {
+		cancel()
+		return nil
+	}
+
+	// act
+	res, err := RunContext(ctx, prg, WithSingleInstance(name))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cause != CauseContext {
+		t.Errorf("Cause, want: %v got: %v", CauseContext, res.Cause)
+	}
+
+	var e *InstanceError
+	if err := <-instErr; !errors.As(err, &e) || !e.Reloaded {
+		t.Errorf("second instance, want: reloaded *InstanceError got: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// +build windows
+
+package svc
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// windows.CreateMutex doesn't report ERROR_ALREADY_EXISTS for an existing mutex,
+// so call CreateMutexW directly.
+var procCreateMutexW = windows.NewLazySystemDLL("kernel32.dll").NewProc("CreateMutexW")
+
+// instance holds the named mutex for this process.
+type instance struct {
+	h windows.Handle
+}
+
+// acquireInstance creates the global named mutex configured with WithSingleInstance
+// or WithSingleUserInstance. Windows closes the handle when the process exits.
+func acquireInstance(o *options) (*instance, error) {
+	if err := checkInstanceName(o.instance); err != nil {
+		return nil, err
+	}
+
+	name := `Global\svc.` + o.instance
+	if o.perUser {
+		user, err := windows.GetCurrentProcessToken().GetTokenUser()
+		if err != nil {
+			return nil, err
+		}
+		name += "." + user.User.Sid.String()
+	}
+
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r, _, e := procCreateMutexW.Call(0, 0, uintptr(unsafe.Pointer(p)))
+	h := windows.Handle(r)
+	if h == 0 {
+		return nil, e
+	}
+	if e == windows.ERROR_ALREADY_EXISTS {
+		if closeErr := windows.CloseHandle(h); closeErr != nil {
+			return nil, closeErr
+		}
+		if o.reloadRunning {
+			o.logf("asking the running instance to reload isn't supported on Windows")
+		}
+		return nil, &InstanceError{Name: o.instance}
+	}
+	return &instance{h: h}, nil
+}
+
+// reloadRequests returns nil; reload requests aren't supported on Windows.
+func (*instance) reloadRequests() <-chan struct{} {
+	return nil
+}
+
+// release closes the mutex handle.
+func (inst *instance) release() error {
+	return windows.CloseHandle(inst.h)
+}
@@ -0,0 +1,143 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"time"
+)
+
+// Create variable os.Exit function so we can mock it in tests
+var osExit = os.Exit
+
+// initService calls the Service's Init method, recovering a panic if enabled.
+func initService(service Service, env Environment, o *options) error {
+	return o.protect(PhaseInit, func() error {
+		return service.Init(env)
+	})
+}
+
+// startService calls the Service's StartContext method if it implements ContextStarter,
+// otherwise its Start method. If the call doesn't return within the configured start
+// timeout the stacks of all goroutines are dumped and a *TimeoutError is returned; the
+// call is left running.
+func startService(service Service, o *options) error {
+	start := func(context.Context) error {
+		return service.Start()
+	}
+	if s, ok := service.(ContextStarter); ok {
+		start = s.StartContext
+	}
+	start = o.protectContext(PhaseStart, start)
+
+	return runPhase(PhaseStart, o.startTimeout, start, o, nil, nil)
+}
+
+// stopService calls the Service's StopContext method if it implements ContextStopper,
+// otherwise its Stop method. If the call doesn't return within the configured stop
+// timeout the stacks of all goroutines are dumped and a *TimeoutError is returned; the
+// call is left running.
+//
+// If f isn't nil the functions started with the Environment's Go method are waited
+// for once the service has stopped, within the same timeout.
+//
+// signals delivers the signals received while stopping. With WithForceExit the first
+// signal whose action is ActionStop aborts the shutdown and exits the process; other
+// signals are ignored. signals may be nil when there's no signal handling, such as
+// under the Windows SCM.
+func stopService(service Service, o *options, f *failures, signals <-chan os.Signal, actions map[os.Signal]SignalAction) error {
+	stop := func(context.Context) error {
+		return service.Stop()
+	}
+	if s, ok := service.(ContextStopper); ok {
+		stop = s.StopContext
+	}
+	stop = o.protectContext(PhaseStop, stop)
+
+	if f != nil {
+		stopService := stop
+		stop = func(ctx context.Context) error {
+			err := stopService(ctx)
+			f.wait()
+			return err
+		}
+	}
+
+	return runPhase(PhaseStop, o.stopTimeout, stop, o, signals, actions)
+}
+
+// runPhase calls fn with a context whose deadline is timeout from now, or without
+// a deadline if timeout is 0, and waits for it to return until the deadline passes.
+func runPhase(phase Phase, timeout time.Duration, fn func(context.Context) error, o *options, signals <-chan os.Signal, actions map[os.Signal]SignalAction) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	if !o.forceExit {
+		signals = nil
+	}
+
+	if timeout <= 0 && signals == nil {
+		return fn(ctx)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fn(ctx)
+	}()
+
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case sig := <-signals:
+			if actions[sig].kind != actionStop {
+				continue
+			}
+			o.logf("received %v while stopping, aborting shutdown", sig)
+			osExit(o.forceExitCode)
+			// osExit only returns when mocked in tests
+			return nil
+		case <-ctx.Done():
+			err := &TimeoutError{Phase: phase, Timeout: timeout}
+			if dumpErr := dumpStacks(o.stackDumpPath, err.Error()+", goroutine stacks:\n\n"); dumpErr != nil {
+				return fmt.Errorf("%w; writing goroutine stacks: %v", err, dumpErr)
+			}
+			return err
+		}
+	}
+}
+
+// dumpStacks writes header and the stacks of all goroutines to the file at path,
+// or to os.Stderr when path is empty.
+func dumpStacks(path, header string) error {
+	if path == "" {
+		return writeStacks(os.Stderr, header)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = writeStacks(f, header)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeStacks(w io.Writer, header string) error {
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	// debug=2 uses the same format as an unrecovered panic
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
@@ -0,0 +1,283 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopServiceTimeout(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.stop = func() error {
+		<-release
+		return nil
+	}
+
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+	opts := testOptions(t, WithStopTimeout(10*time.Millisecond), WithStackDumpFile(path))
+
+	// act
+	err := stopService(prg, opts, nil, nil, nil)
+
+	// assert
+	if !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("stopService, want: %v got: %v", ErrStopTimeout, err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "svc: stop phase overran its 10ms deadline") {
+		t.Errorf("stack dump header, got: %q", firstLine(string(b)))
+	}
+	if !strings.Contains(string(b), "TestStopServiceTimeout") {
+		t.Error("stack dump doesn't contain the test's goroutine")
+	}
+}
+
+func TestStopServiceWithinTimeout(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.stop = func() error {
+		stopCalled++
+		return errors.New("stop error")
+	}
+
+	opts := testOptions(t, WithStopTimeout(time.Minute))
+
+	// act
+	err := stopService(prg, opts, nil, nil, nil)
+
+	// assert
+	if err == nil || err.Error() != "stop error" {
+		t.Errorf("stopService, want: stop error got: %v", err)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func TestStopServiceForceExit(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.stop = func() error {
+		<-release
+		return nil
+	}
+
+	exitCode := -1
+	osExit = func(code int) {
+		exitCode = code
+	}
+	defer func() {
+		osExit = os.Exit
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+
+	// act
+	err := stopService(prg, testOptions(t, WithForceExit(130)), nil, signals, map[os.Signal]SignalAction{os.Interrupt: ActionStop})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 130 {
+		t.Errorf("exit code, want: 130 got: %d", exitCode)
+	}
+}
+
+func TestStopServiceIgnoresSignals(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	prg.stop = func() error {
+		<-release
+		stopCalled++
+		return nil
+	}
+
+	osExit = func(code int) {
+		t.Errorf("osExit(%d) called without WithForceExit", code)
+	}
+	defer func() {
+		osExit = os.Exit
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+	time.AfterFunc(20*time.Millisecond, func() {
+		close(release)
+	})
+
+	// act
+	err := stopService(prg, testOptions(t, WithStopTimeout(time.Minute)), nil, signals, map[os.Signal]SignalAction{os.Interrupt: ActionStop})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+func TestStartServiceTimeout(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.start = func() error {
+		<-release
+		return nil
+	}
+
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+	opts := testOptions(t, WithStartTimeout(10*time.Millisecond), WithStackDumpFile(path))
+
+	// act
+	err := startService(prg, opts)
+
+	// assert
+	if !errors.Is(err, ErrStartTimeout) {
+		t.Fatalf("startService, want: %v got: %v", ErrStartTimeout, err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "svc: start phase overran its 10ms deadline") {
+		t.Errorf("stack dump header, got: %q", firstLine(string(b)))
+	}
+}
+
+type contextLifecycleProgram struct {
+	*mockProgram
+	startContext func(context.Context) error
+	stopContext  func(context.Context) error
+}
+
+func (p *contextLifecycleProgram) StartContext(ctx context.Context) error {
+	return p.startContext(ctx)
+}
+
+func (p *contextLifecycleProgram) StopContext(ctx context.Context) error {
+	return p.stopContext(ctx)
+}
+
+func TestStopServiceContext(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := &contextLifecycleProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled)}
+
+	var deadline time.Time
+	var hasDeadline bool
+	prg.stopContext = func(ctx context.Context) error {
+		deadline, hasDeadline = ctx.Deadline()
+		return nil
+	}
+
+	// act
+	begin := time.Now()
+	err := stopService(prg, testOptions(t, WithStopTimeout(time.Minute)), nil, nil, nil)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stopCalled != 0 {
+		t.Errorf("stopCalled, want: 0 got: %d", stopCalled)
+	}
+	if !hasDeadline || deadline.Before(begin.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("deadline, want: ~%v got: %v (%v)", begin.Add(time.Minute), deadline, hasDeadline)
+	}
+}
+
+func TestStartServiceContextNoTimeout(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := &contextLifecycleProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled)}
+
+	hasDeadline := true
+	prg.startContext = func(ctx context.Context) error {
+		_, hasDeadline = ctx.Deadline()
+		return errors.New("start error")
+	}
+
+	// act
+	err := startService(prg, testOptions(t))
+
+	// assert
+	if err == nil || err.Error() != "start error" {
+		t.Errorf("startService, want: start error got: %v", err)
+	}
+	if hasDeadline {
+		t.Error("deadline, want: none")
+	}
+	if startCalled != 0 {
+		t.Errorf("startCalled, want: 0 got: %d", startCalled)
+	}
+}
+
+func TestStopServiceContextOverrun(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := &contextLifecycleProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled)}
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.stopContext = func(ctx context.Context) error {
+		// ignores ctx
+		<-release
+		return nil
+	}
+
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+
+	// act
+	err := stopService(prg, testOptions(t, WithStopTimeout(10*time.Millisecond), WithStackDumpFile(path)), nil, nil, nil)
+
+	// assert
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("stopService, want: *TimeoutError got: %v", err)
+	}
+	if timeoutErr.Phase != PhaseStop {
+		t.Errorf("Phase, want: %v got: %v", PhaseStop, timeoutErr.Phase)
+	}
+	if timeoutErr.Timeout != 10*time.Millisecond {
+		t.Errorf("Timeout, want: 10ms got: %v", timeoutErr.Timeout)
+	}
+	if !errors.Is(err, ErrStopTimeout) || errors.Is(err, ErrStartTimeout) {
+		t.Errorf("errors.Is, want: ErrStopTimeout only got: %v", err)
+	}
+}
@@ -0,0 +1,416 @@
+package svc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// An Option configures Run and RunContext.
+//
+// Options are passed to Run alongside the signals it handles, so Option implements
+// os.Signal. Options are never registered for or delivered as signals: Run returns
+// an error if one is passed to WithSignals or WithReloadSignals.
+type Option func(*options)
+
+// String implements os.Signal.
+func (Option) String() string {
+	return "svc.Option"
+}
+
+// Signal implements os.Signal.
+func (Option) Signal() {}
+
+// options holds the configuration built from the Options passed to Run.
+type options struct {
+	name          string
+	logger        Logger
+	stopSignals   []os.Signal
+	startTimeout  time.Duration
+	stopTimeout   time.Duration
+	stackDumpPath string
+	forceExit     bool
+	forceExitCode int
+	reloadSignals []os.Signal
+	signalActions map[os.Signal]SignalAction
+	onExit        []func(Result, error)
+	hooks         []Hooks
+	recoverPanics bool
+	crashDir      string
+	exitCode      func(Result, error) int
+	pidFile       string
+	instance      string
+	perUser       bool
+	reloadRunning bool
+	daemon        *Daemon
+	user          string
+	group         string
+	containerInit ContainerInit
+}
+
+// logf logs a message from Run, prefixed with "svc: " and the service name.
+func (o *options) logf(format string, v ...interface{}) {
+	prefix := "svc: "
+	if o.name != "" {
+		prefix += o.name + ": "
+	}
+	o.logger.Printf(prefix+format, v...)
+}
+
+// A Logger logs the messages Run writes when something goes wrong which doesn't
+// stop the service, such as a failed reload. *log.Logger implements Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger logs to the standard logger.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// WithName sets the name of the service. On Windows it's the name passed to the
+// SCM; everywhere it prefixes the messages Run logs.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithLogger sets the Logger for the messages Run logs. The default is the
+// standard logger from the log package.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithStartTimeout limits how long Run waits for the Service's Start method to return.
+// When the timeout expires the stacks of all goroutines are written to os.Stderr, or the
+// file set with WithStackDumpFile, and Run returns a *TimeoutError matching ErrStartTimeout
+// without calling Stop. The timeout is the deadline of the context passed to StartContext;
+// see ContextStarter.
+//
+// A timeout of 0, the default, waits indefinitely.
+func WithStartTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.startTimeout = d
+	}
+}
+
+// WithOnExit adds a function to call when Run is about to return, with the
+// Result and error it's returning.
+func WithOnExit(fn func(Result, error)) Option {
+	return func(o *options) {
+		o.onExit = append(o.onExit, fn)
+	}
+}
+
+// WithStopTimeout limits how long Run waits for the Service's Stop method to return.
+// When the timeout expires the stacks of all goroutines are written to os.Stderr, or
+// the file set with WithStackDumpFile, and Run returns a *TimeoutError matching
+// ErrStopTimeout. The timeout is the deadline of the context passed to StopContext;
+// see ContextStopper.
+//
+// A timeout of 0 waits indefinitely. The default is read from the SVC_STOP_TIMEOUT
+// environment variable, for example "80s". systemd doesn't pass TimeoutStopSec= on to
+// the process, so set SVC_STOP_TIMEOUT a little lower with Environment= in the unit file.
+func WithStopTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.stopTimeout = d
+	}
+}
+
+// WithStackDumpFile makes goroutine stack dumps append to the file at path instead of
+// being written to os.Stderr.
+func WithStackDumpFile(path string) Option {
+	return func(o *options) {
+		o.stackDumpPath = path
+	}
+}
+
+// WithPanicRecovery makes Run recover panics in the Service's Init, Start, Stop and
+// Reload methods, and their context variants, returning a *PanicError carrying the
+// stack instead of crashing. A recovered panic in Reload is logged like a failed
+// reload. Panics in goroutines the service starts itself aren't recovered.
+func WithPanicRecovery() Option {
+	return func(o *options) {
+		o.recoverPanics = true
+	}
+}
+
+// WithCrashReports enables panic recovery, see WithPanicRecovery, and writes a crash
+// report for each recovered panic to a new file in dir. The report holds the panic
+// value and stack, the stacks of all goroutines and the program's build information.
+func WithCrashReports(dir string) Option {
+	return func(o *options) {
+		o.recoverPanics = true
+		o.crashDir = dir
+	}
+}
+
+// WithExitCodes sets the policy mapping how the service ended to the process exit
+// code used by Main, and reported to the SCM when running as a Windows Service.
+// fn is also called when err is nil. The default, or when fn is nil, is DefaultExitCode.
+func WithExitCodes(fn func(res Result, err error) int) Option {
+	return func(o *options) {
+		if fn == nil {
+			fn = DefaultExitCode
+		}
+		o.exitCode = fn
+	}
+}
+
+// WithPIDFile makes Run write the process ID to the file at path before calling Init,
+// holding an advisory lock (flock) on it while the service runs, and remove it once
+// the service has stopped. If another process holds the lock Run returns a
+// *PIDFileError matching ErrAlreadyRunning without calling Init. A file left behind
+// by a process which died is replaced.
+//
+// The file is written to a temporary file in the same directory and renamed, so
+// readers never see it partially written. Pidfiles aren't supported on Windows.
+func WithPIDFile(path string) Option {
+	return func(o *options) {
+		o.pidFile = path
+	}
+}
+
+// WithSingleInstance makes Run refuse to run while another process on the host runs
+// with the same instance name: Run returns an *InstanceError matching
+// ErrAlreadyRunning before calling Init. The name is held for as long as the process
+// runs and released when it exits, however it exits.
+//
+// On Linux the name is an abstract unix socket, which is scoped to the network
+// namespace; elsewhere it's a lock file in os.TempDir(). On Windows it's a global
+// named mutex.
+func WithSingleInstance(name string) Option {
+	return func(o *options) {
+		o.instance = name
+		o.perUser = false
+	}
+}
+
+// WithSingleUserInstance is like WithSingleInstance, but only refuses to run while
+// another process run by the same user holds the name.
+func WithSingleUserInstance(name string) Option {
+	return func(o *options) {
+		o.instance = name
+		o.perUser = true
+	}
+}
+
+// WithReloadRunningInstance makes Run, when WithSingleInstance or
+// WithSingleUserInstance finds another instance running, ask that instance to reload
+// as though it received a reload signal, before returning the *InstanceError.
+// Only processes run by the same user, or root, can ask an instance to reload.
+// Reload requests aren't supported on Windows.
+func WithReloadRunningInstance() Option {
+	return func(o *options) {
+		o.reloadRunning = true
+	}
+}
+
+// A Daemon configures the process started by WithDaemon.
+type Daemon struct {
+	// Stdout and Stderr are the files the daemon's standard output and error are
+	// appended to, created if needed. "" means /dev/null.
+	Stdout string
+	Stderr string
+	// Umask points to the daemon's file mode creation mask, for example 027. Nil
+	// keeps the mask the program was started with.
+	Umask *int
+	// Dir is the daemon's working directory. "" means "/".
+	Dir string
+}
+
+// WithDaemon makes Run detach the program from its terminal, for systems without a
+// service manager. Run starts the program again, with the same arguments, in a new
+// session with standard input from /dev/null and standard output and error
+// redirected as configured, and waits for it to start. Once the Service's Start
+// method has returned in the new process the original process exits with status 0;
+// if the new process fails to start, Run returns its error instead. The new process
+// runs the normal lifecycle, so pidfiles and instance names are held by it.
+//
+// Daemonizing isn't supported on Windows.
+func WithDaemon(d Daemon) Option {
+	return func(o *options) {
+		o.daemon = &d
+	}
+}
+
+// WithUser makes Run switch to the given user and group after the Service's Init
+// method returns and before Start is called, so Init can bind privileged ports and
+// read protected files. user and group are names or numeric IDs; if group is "" the
+// user's primary group is used. The supplementary groups are set to the user's
+// groups. The switch applies to every thread of the process, and Run checks that
+// the original user can't be regained.
+//
+// On Linux this needs a program built with Go 1.16 or later; otherwise Run returns
+// an error rather than switching only some threads. Files Run removes after Stop,
+// such as the pidfile, must be removable by the new user. Switching user isn't
+// supported on Windows.
+func WithUser(user, group string) Option {
+	return func(o *options) {
+		o.user = user
+		o.group = group
+	}
+}
+
+// ContainerInit says when Run acts as the init process of a container. See
+// WithContainerInit.
+type ContainerInit int
+
+const (
+	// ContainerInitAuto acts as the container's init process when the process is PID 1.
+	ContainerInitAuto ContainerInit = iota + 1
+	// ContainerInitAlways acts as the container's init process whatever the process ID.
+	ContainerInitAlways
+)
+
+// WithContainerInit makes Run take on the duties of a container's init process,
+// on Linux, as configured by mode:
+//
+// Orphaned processes are reparented to the init process and become zombies when
+// they exit unless it waits for them. Run waits for child processes which have
+// been zombies for a while, leaving time for the commands the program started with
+// os/exec to be waited for by their Cmd. Commands whose Wait is called long after
+// they exit may see an error as the process was already reaped.
+//
+// Signals which stop the service are forwarded to the other processes in the
+// process group before Stop is called, so commands the program started stop too.
+//
+// ContainerInitAlways isn't supported on other systems; ContainerInitAuto is ignored.
+func WithContainerInit(mode ContainerInit) Option {
+	return func(o *options) {
+		o.containerInit = mode
+	}
+}
+
+// WithForceExit makes a signal received while the Service's Stop method is running
+// abort the shutdown: Run logs that the shutdown was aborted and exits the process
+// immediately with the given exit code. This matches the expectation that pressing
+// Ctrl+C twice, or a supervisor sending SIGTERM again, kills the process.
+//
+// Without this option signals received while stopping are ignored.
+func WithForceExit(code int) Option {
+	return func(o *options) {
+		o.forceExit = true
+		o.forceExitCode = code
+	}
+}
+
+// WithReloadSignals sets the signals which call the Service's Reload method when it
+// implements Reloader. The default is syscall.SIGHUP on Unix-like systems and no
+// signals on Windows, where Reload is triggered by the SCM instead.
+func WithReloadSignals(sig ...os.Signal) Option {
+	return func(o *options) {
+		o.reloadSignals = sig
+	}
+}
+
+// WithSignalActions sets what Run does for each signal in actions, overriding the
+// stop and reload signals. For example, to rotate logs on SIGUSR1 and dump the
+// goroutine stacks on SIGQUIT without exiting:
+//
+//	svc.Run(prg, svc.WithSignalActions(map[os.Signal]svc.SignalAction{
+//		syscall.SIGUSR1: svc.ActionReopenLogs,
+//		syscall.SIGQUIT: svc.ActionDumpGoroutines,
+//	}))
+//
+// Passing WithSignalActions more than once merges the maps.
+func WithSignalActions(actions map[os.Signal]SignalAction) Option {
+	return func(o *options) {
+		if o.signalActions == nil {
+			o.signalActions = make(map[os.Signal]SignalAction, len(actions))
+		}
+		for sig, action := range actions {
+			o.signalActions[sig] = action
+		}
+	}
+}
+
+// WithSignals sets the signals which stop the service. The default is syscall.SIGINT
+// and syscall.SIGTERM, or only syscall.SIGINT (Ctrl+C) on Windows.
+func WithSignals(sig ...os.Signal) Option {
+	return func(o *options) {
+		o.stopSignals = sig
+	}
+}
+
+// exit calls the WithOnExit functions.
+func (o *options) exit(res Result, err error) {
+	for _, fn := range o.onExit {
+		fn(res, err)
+	}
+}
+
+// newOptions applies opts on top of the defaults taken from the environment.
+func newOptions(opts []Option) (*options, error) {
+	o := &options{
+		logger:        stdLogger{},
+		stopSignals:   defaultStopSignals,
+		reloadSignals: defaultReloadSignals,
+		exitCode:      DefaultExitCode,
+	}
+
+	if s := os.Getenv("SVC_STOP_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("svc: invalid SVC_STOP_TIMEOUT %q: %w", s, err)
+		}
+		o.stopTimeout = d
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if err := checkSignals("WithSignals", o.stopSignals); err != nil {
+		return nil, err
+	}
+	if err := checkSignals("WithReloadSignals", o.reloadSignals); err != nil {
+		return nil, err
+	}
+
+	// the new process would find the pidfile or instance name taken by the old one
+	for _, action := range o.signalActions {
+		if action == ActionUpgrade && (o.pidFile != "" || o.instance != "") {
+			return nil, errors.New("svc: ActionUpgrade can't be used with WithPIDFile or WithSingleInstance")
+		}
+	}
+
+	return o, nil
+}
+
+// checkSignals returns an error if sig, passed to the Option named name, holds an
+// Option, which compiles there because Option implements os.Signal.
+func checkSignals(name string, sig []os.Signal) error {
+	for _, s := range sig {
+		if _, ok := s.(Option); ok {
+			return fmt.Errorf("svc: %s given an Option instead of a signal", name)
+		}
+	}
+	return nil
+}
+
+// signalOptions converts the arguments passed to Run into Options. The signals
+// among them become a WithSignals Option.
+func signalOptions(sig []os.Signal) []Option {
+	var opts []Option
+	var signals []os.Signal
+	for _, s := range sig {
+		if opt, ok := s.(Option); ok {
+			opts = append(opts, opt)
+			continue
+		}
+		signals = append(signals, s)
+	}
+
+	if len(signals) != 0 {
+		opts = append(opts, WithSignals(signals...))
+	}
+	return opts
+}
@@ -0,0 +1,136 @@
+package svc
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalOptions(t *testing.T) {
+	setenv(t, "SVC_STOP_TIMEOUT", "")
+
+	opts, err := newOptions(signalOptions([]os.Signal{
+		syscall.SIGINT,
+		WithStopTimeout(5 * time.Second),
+		syscall.SIGTERM,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []os.Signal{syscall.SIGINT, syscall.SIGTERM}; !reflect.DeepEqual(want, opts.stopSignals) {
+		t.Errorf("stopSignals, want: %v got: %v", want, opts.stopSignals)
+	}
+	if opts.stopTimeout != 5*time.Second {
+		t.Errorf("stopTimeout, want: 5s got: %v", opts.stopTimeout)
+	}
+}
+
+func TestSignalOptionsDefaultSignals(t *testing.T) {
+	opts, err := newOptions(signalOptions([]os.Signal{WithStopTimeout(time.Second)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(defaultStopSignals, opts.stopSignals) {
+		t.Errorf("stopSignals, want: %v got: %v", defaultStopSignals, opts.stopSignals)
+	}
+}
+
+func TestNewOptionsStopTimeoutEnv(t *testing.T) {
+	setenv(t, "SVC_STOP_TIMEOUT", "80s")
+
+	opts, err := newOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.stopTimeout != 80*time.Second {
+		t.Errorf("stopTimeout, want: 80s got: %v", opts.stopTimeout)
+	}
+
+	// an explicit option wins over the environment
+	opts, err = newOptions([]Option{WithStopTimeout(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.stopTimeout != 0 {
+		t.Errorf("stopTimeout, want: 0s got: %v", opts.stopTimeout)
+	}
+}
+
+func TestNewOptionsStopTimeoutEnvInvalid(t *testing.T) {
+	setenv(t, "SVC_STOP_TIMEOUT", "soon")
+
+	if _, err := newOptions(nil); err == nil {
+		t.Error("newOptions with SVC_STOP_TIMEOUT=soon, want: error got: <nil>")
+	}
+}
+
+func TestNewOptionsUpgradeWithPIDFile(t *testing.T) {
+	upgrade := WithSignalActions(map[os.Signal]SignalAction{syscall.SIGHUP: ActionUpgrade})
+
+	for _, opt := range []Option{WithPIDFile("svc.pid"), WithSingleInstance("svc")} {
+		_, err := newOptions([]Option{upgrade, opt})
+		if want := "svc: ActionUpgrade can't be used with WithPIDFile or WithSingleInstance"; err == nil || err.Error() != want {
+			t.Errorf("newOptions, want: %q got: %v", want, err)
+		}
+	}
+}
+
+func TestNewOptionsOptionAsSignal(t *testing.T) {
+	setenv(t, "SVC_STOP_TIMEOUT", "")
+
+	cases := []struct {
+		opt  Option
+		want string
+	}{
+		{opt: WithSignals(syscall.SIGINT, WithStopTimeout(time.Second)), want: "svc: WithSignals given an Option instead of a signal"},
+		{opt: WithReloadSignals(WithStopTimeout(time.Second)), want: "svc: WithReloadSignals given an Option instead of a signal"},
+	}
+
+	for _, c := range cases {
+		_, err := newOptions([]Option{c.opt})
+		if err == nil || err.Error() != c.want {
+			t.Errorf("newOptions, want: %q got: %v", c.want, err)
+		}
+	}
+}
+
+// testOptions returns the options built from opts, ignoring the environment.
+func testOptions(t *testing.T, opts ...Option) *options {
+	t.Helper()
+
+	setenv(t, "SVC_STOP_TIMEOUT", "")
+
+	o, err := newOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestOptionsLogf(t *testing.T) {
+	logger := &recordingLogger{}
+
+	testOptions(t, WithLogger(logger)).logf("reload failed: %v", "boom")
+	testOptions(t, WithLogger(logger), WithName("awesome")).logf("reload failed: %v", "boom")
+
+	want := []string{
+		"svc: reload failed: boom",
+		"svc: awesome: reload failed: boom",
+	}
+	if !reflect.DeepEqual(want, logger.messages) {
+		t.Errorf("messages, want: %q got: %q", want, logger.messages)
+	}
+}
@@ -0,0 +1,133 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// A PanicError is returned by Run when the Service panics in a lifecycle method and
+// panic recovery is enabled with WithPanicRecovery or WithCrashReports.
+type PanicError struct {
+	// Phase is the lifecycle phase which panicked. Panics in Reload are PhaseRun.
+	Phase Phase
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack of the panicking goroutine, as returned by debug.Stack.
+	Stack []byte
+	// CrashReport is the path of the crash report written, if any.
+	CrashReport string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("svc: panic during %s: %v", e.Phase, e.Value)
+}
+
+// Unwrap returns the panic value if it's an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// protect calls fn, returning a *PanicError for phase if it panics and panic recovery
+// is enabled.
+func (o *options) protect(phase Phase, fn func() error) (err error) {
+	if !o.recoverPanics {
+		return fn()
+	}
+
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+
+		panicErr := &PanicError{Phase: phase, Value: v, Stack: debug.Stack()}
+		if o.crashDir != "" {
+			path, reportErr := writeCrashReport(o.crashDir, o.name, panicErr)
+			if reportErr != nil {
+				o.logf("writing crash report: %v", reportErr)
+			} else {
+				panicErr.CrashReport = path
+			}
+		}
+		err = panicErr
+	}()
+
+	return fn()
+}
+
+// protectContext returns fn protected as a lifecycle call for phase. See protect.
+func (o *options) protectContext(phase Phase, fn func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return o.protect(phase, func() error {
+			return fn(ctx)
+		})
+	}
+}
+
+// writeCrashReport writes a crash report for err to a new file in dir, returning
+// its path.
+func writeCrashReport(dir, name string, err *PanicError) (string, error) {
+	if name == "" {
+		name = filepath.Base(os.Args[0])
+	}
+	now := time.Now()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v\n\n", err)
+	fmt.Fprintf(&buf, "time: %s\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&buf, "pid: %d\n", os.Getpid())
+	fmt.Fprintf(&buf, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&buf, "path: %s\n", info.Path)
+		fmt.Fprintf(&buf, "mod: %s %s %s\n", info.Main.Path, info.Main.Version, info.Main.Sum)
+		for _, dep := range info.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			fmt.Fprintf(&buf, "dep: %s %s %s\n", dep.Path, dep.Version, dep.Sum)
+		}
+	}
+	fmt.Fprintf(&buf, "\npanicking goroutine:\n\n%s\n", err.Stack)
+	if stacksErr := writeStacks(&buf, "all goroutines:\n\n"); stacksErr != nil {
+		return "", stacksErr
+	}
+
+	if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
+		return "", mkErr
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("crash-%s-%s-%d", name, now.UTC().Format("20060102T150405Z"), os.Getpid()))
+	for i := 0; ; i++ {
+		path := base + ".txt"
+		if i > 0 {
+			// more than one report in the same second
+			path = fmt.Sprintf("%s-%d.txt", base, i)
+		}
+
+		f, openErr := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(openErr) {
+			continue
+		}
+		if openErr != nil {
+			return "", openErr
+		}
+
+		_, writeErr := f.Write(buf.Bytes())
+		if closeErr := f.Close(); writeErr == nil {
+			writeErr = closeErr
+		}
+		if writeErr != nil {
+			return "", writeErr
+		}
+		return path, nil
+	}
+}
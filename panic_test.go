@@ -0,0 +1,112 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRunContextPanicRecovery(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.start = func() error {
+		panic("nil map")
+	}
+
+	// act
+	res, err := RunContext(context.Background(), prg, WithPanicRecovery())
+
+	// assert
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("RunContext, want: *PanicError got: %v", err)
+	}
+	if got, want := err.Error(), "svc: panic during start: nil map"; got != want {
+		t.Errorf("error, want: %q got: %q", want, got)
+	}
+	if !bytes.Contains(panicErr.Stack, []byte("panic_test.go")) {
+		t.Errorf("Stack doesn't include the panicking function:\n%s", panicErr.Stack)
+	}
+	if panicErr.CrashReport != "" {
+		t.Errorf("CrashReport, want: none got: %q", panicErr.CrashReport)
+	}
+	if res.Phase != PhaseStart {
+		t.Errorf("Phase, want: %v got: %v", PhaseStart, res.Phase)
+	}
+	if stopCalled != 0 {
+		t.Errorf("stopCalled, want: 0 got: %d", stopCalled)
+	}
+}
+
+func TestRunContextCrashReport(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	initErr := errors.New("bad config")
+	prg.init = func(Environment) error {
+		panic(initErr)
+	}
+	dir := t.TempDir()
+
+	// act
+	_, err := RunContext(context.Background(), prg, WithCrashReports(dir), WithName("awesome"))
+
+	// assert
+	if !errors.Is(err, initErr) {
+		t.Errorf("RunContext, want: %v got: %v", initErr, err)
+	}
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("RunContext, want: *PanicError got: %v", err)
+	}
+	if !strings.HasPrefix(panicErr.CrashReport, dir) || !strings.Contains(panicErr.CrashReport, "crash-awesome-") {
+		t.Errorf("CrashReport, want: %s/crash-awesome-* got: %q", dir, panicErr.CrashReport)
+	}
+
+	b, readErr := ioutil.ReadFile(panicErr.CrashReport)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	report := string(b)
+	for _, want := range []string{"svc: panic during init: bad config\n", "panicking goroutine:", "all goroutines:", "panic_test.go"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("crash report doesn't contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestReloadPanicRecovery(t *testing.T) {
+	// arrange
+	logger := &recordingLogger{}
+	o := testOptions(t, WithPanicRecovery(), WithLogger(logger))
+	prg := &reloadProgram{reload: func() error {
+		panic("boom")
+	}}
+
+	// act
+	reloadService(prg, o)
+
+	// assert
+	want := []string{"svc: reload failed: svc: panic during run: boom"}
+	if len(logger.messages) != 1 || logger.messages[0] != want[0] {
+		t.Errorf("messages, want: %q got: %q", want, logger.messages)
+	}
+}
+
+func TestProtectWithoutRecovery(t *testing.T) {
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Errorf("recover, want: boom got: %v", v)
+		}
+	}()
+
+	err := testOptions(t).protect(PhaseStart, func() error {
+		panic("boom")
+	})
+	t.Errorf("panic recovered without WithPanicRecovery: %v", err)
+}
@@ -0,0 +1,23 @@
+package svc
+
+import "fmt"
+
+// A PIDFileError is returned by Run when the pidfile is locked by another process.
+// It matches ErrAlreadyRunning using errors.Is.
+type PIDFileError struct {
+	Path string
+	// PID is the process ID read from the pidfile, or 0 if it couldn't be read.
+	PID int
+}
+
+func (e *PIDFileError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("svc: pidfile %s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("svc: pidfile %s is locked by running process %d", e.Path, e.PID)
+}
+
+// Is reports whether target is ErrAlreadyRunning.
+func (e *PIDFileError) Is(target error) bool {
+	return target == ErrAlreadyRunning
+}
@@ -0,0 +1,144 @@
+// +build !windows
+
+package svc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// pidFile is a pidfile locked by this process.
+type pidFile struct {
+	path string
+	f    *os.File
+}
+
+// lockPIDFile locks the pidfile at path and writes the process ID to it.
+//
+// The lock is taken on the file at path and then moved to a new file holding the
+// PID, which is renamed over path. Another process which opened the old file
+// before the rename finds, once it holds the lock, that the file it locked is no
+// longer the one at path, and tries again.
+func lockPIDFile(path string, o *options) (*pidFile, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			pid, _ := readPID(f)
+			if closeErr := f.Close(); closeErr != nil {
+				return nil, closeErr
+			}
+			if err == syscall.EWOULDBLOCK {
+				return nil, &PIDFileError{Path: path, PID: pid}
+			}
+			return nil, fmt.Errorf("svc: locking pidfile %s: %w", path, err)
+		}
+
+		same, err := sameFile(f, path)
+		if err != nil || !same {
+			if closeErr := f.Close(); closeErr != nil {
+				return nil, closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// the previous owner's lock was released when it exited
+		if pid, ok := readPID(f); ok && pid != os.Getpid() {
+			o.logf("replacing stale pidfile %s left by process %d", path, pid)
+		}
+
+		p, err := writePIDFile(path)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return p, err
+	}
+}
+
+// writePIDFile writes the process ID to a new locked file in the same directory
+// as path and renames it to path.
+func writePIDFile(path string) (*pidFile, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(err error) (*pidFile, error) {
+		if closeErr := f.Close(); closeErr != nil {
+			return nil, closeErr
+		}
+		if removeErr := os.Remove(f.Name()); removeErr != nil {
+			return nil, removeErr
+		}
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return fail(fmt.Errorf("svc: locking pidfile %s: %w", path, err))
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fail(err)
+	}
+
+	return &pidFile{path: path, f: f}, nil
+}
+
+// unlock removes the pidfile and then releases the lock, so a process waiting for
+// the lock finds the file gone and creates a new one.
+func (p *pidFile) unlock() error {
+	err := os.Remove(p.path)
+	if closeErr := p.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sameFile reports whether f is still the file at path.
+func sameFile(f *os.File, path string) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi, pi), nil
+}
+
+// readPID reads the process ID from the start of f.
+func readPID(f *os.File) (int, bool) {
+	b := make([]byte, 32)
+	n, err := f.ReadAt(b, 0)
+	if n == 0 && err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b[:n])))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, true
+}
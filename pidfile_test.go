@@ -0,0 +1,121 @@
+// +build !windows
+
+package svc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestRunContextPIDFile(t *testing.T) {
+	// arrange
+	path := filepath.Join(t.TempDir(), "awesome.pid")
+
+	var startCalled, stopCalled, initCalled int
+	ctx, cancel := context.WithCancel(context.Background())
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	var envPath string
+	var contents []byte
+	prg.init = func(env Environment) error {
+		envPath = env.PIDFile()
+		return nil
+	}
+	prg.start = func() error {
+		var err error
+		contents, err = ioutil.ReadFile(path)
+		cancel()
+		return err
+	}
+
+	// act
+	_, err := RunContext(ctx, prg, WithPIDFile(path))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envPath != path {
+		t.Errorf("PIDFile, want: %q got: %q", path, envPath)
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; string(contents) != want {
+		t.Errorf("pidfile contents, want: %q got: %q", want, contents)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pidfile not removed after stop: %v", err)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("files left behind: %d", len(files))
+	}
+}
+
+func TestRunContextPIDFileLocked(t *testing.T) {
+	// arrange
+	path := filepath.Join(t.TempDir(), "awesome.pid")
+
+	held, err := lockPIDFile(path, testOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := held.unlock(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	// act
+	_, err = RunContext(context.Background(), prg, WithPIDFile(path))
+
+	// assert
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("RunContext, want: %v got: %v", ErrAlreadyRunning, err)
+	}
+	var pidErr *PIDFileError
+	if !errors.As(err, &pidErr) || pidErr.PID != os.Getpid() {
+		t.Errorf("PIDFileError, want PID: %d got: %v", os.Getpid(), err)
+	}
+	if initCalled != 0 {
+		t.Errorf("initCalled, want: 0 got: %d", initCalled)
+	}
+}
+
+func TestRunContextPIDFileStale(t *testing.T) {
+	// arrange
+	path := filepath.Join(t.TempDir(), "awesome.pid")
+	if err := ioutil.WriteFile(path, []byte("4194305\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var startCalled, stopCalled, initCalled int
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	prg := &contextProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled), ctx: ctx}
+	logger := &recordingLogger{}
+
+	// act
+	_, err := RunContext(context.Background(), prg, WithPIDFile(path), WithLogger(logger))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "svc: replacing stale pidfile " + path + " left by process 4194305"
+	if len(logger.messages) != 1 || logger.messages[0] != want {
+		t.Errorf("messages, want: %q got: %q", want, logger.messages)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pidfile not removed after stop: %v", err)
+	}
+}
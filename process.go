@@ -0,0 +1,304 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults for ProcessService.
+const (
+	DefaultProcessStopTimeout  = 10 * time.Second
+	DefaultProcessRestartDelay = time.Second
+)
+
+// A ProcessService is a Service which runs an external command, such as a legacy
+// binary the program wraps.
+//
+// Start starts the command. Stop sends it StopSignal and waits up to StopTimeout
+// for it to exit before killing it. If the command exits while the service is
+// running it's started again after RestartDelay when its Restart policy allows.
+// Otherwise a command which failed is reported through the Environment's Fail
+// method as an *ExitError carrying its exit code, so Run stops and returns it,
+// and a command which exited with status 0 makes Run stop without an error by
+// ending the service's Context. The exit code of a command killed by a signal is
+// 128 plus the signal number, as in the shell.
+//
+// A ProcessService is a Waiter: Wait returns nil once the command exited with
+// status 0 or was stopped, and the *ExitError otherwise. For restarts with
+// backoff and a restart limit, leave Restart unset and add the ProcessService to
+// a Supervisor, which then learns of the exit only through Wait.
+type ProcessService struct {
+	// Path is the command to run, looked up in PATH if it contains no separators.
+	Path string
+	// Args holds the command's arguments, not including the command itself.
+	Args []string
+	// Env is the command's environment. Nil means the environment of this process.
+	Env []string
+	// Dir is the command's working directory. "" means the working directory of
+	// this process.
+	Dir string
+	// Stdout and Stderr receive the command's standard output and error. Nil means
+	// the null device.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// StopSignal is sent to the command to stop it. Nil means syscall.SIGTERM.
+	// On Windows, where signals can't be sent, the command is killed.
+	StopSignal os.Signal
+	// StopTimeout is how long the command has to exit after StopSignal before
+	// it's killed. Zero means DefaultProcessStopTimeout.
+	StopTimeout time.Duration
+
+	// Restart says whether the command is started again when it exits while the
+	// service is running. Zero means RestartNever.
+	Restart RestartPolicy
+	// RestartDelay is the time between the command exiting and being started
+	// again. Zero means DefaultProcessRestartDelay.
+	RestartDelay time.Duration
+
+	// Logger receives restart messages. Nil means the log package's standard logger.
+	Logger Logger
+
+	env        Environment
+	supervised bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	ended    chan error
+	restart  *time.Timer
+	stopping bool
+}
+
+// Init keeps env to report the command's exit through, unless the ProcessService
+// is a Supervisor's child.
+func (p *ProcessService) Init(env Environment) error {
+	_, supervised := env.(*childEnvironment)
+	p.env, p.supervised = env, supervised
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Context returns a context which is done once the command has exited with
+// status 0 and won't be restarted.
+func (p *ProcessService) Context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// Start starts the command.
+func (p *ProcessService) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopping = false
+	p.ended = make(chan error, 1)
+	return p.start()
+}
+
+// Wait waits until the command has exited and won't be restarted, or has been
+// stopped. It returns the *ExitError of a command which failed, otherwise nil.
+func (p *ProcessService) Wait() error {
+	p.mu.Lock()
+	ended := p.ended
+	p.mu.Unlock()
+
+	return <-ended
+}
+
+// start starts the command. p.mu must be held.
+func (p *ProcessService) start() error {
+	cmd := exec.Command(p.Path, p.Args...)
+	cmd.Env = p.Env
+	cmd.Dir = p.Dir
+	cmd.Stdout = p.Stdout
+	cmd.Stderr = p.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	p.cmd, p.exited = cmd, exited
+	go p.wait(cmd, exited)
+	return nil
+}
+
+// wait waits for cmd to exit, then restarts it or reports its exit.
+func (p *ProcessService) wait(cmd *exec.Cmd, exited chan struct{}) {
+	waitErr := cmd.Wait()
+	close(exited)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopping {
+		p.end(nil)
+		return
+	}
+
+	// a command exiting with status 0 has no *ExitError
+	var err *ExitError
+	if waitErr != nil {
+		err = p.exitError(cmd, waitErr)
+	}
+	if !p.restartable(err) {
+		if err != nil {
+			p.end(err)
+		} else {
+			p.end(nil)
+		}
+		return
+	}
+
+	delay := p.RestartDelay
+	if delay <= 0 {
+		delay = DefaultProcessRestartDelay
+	}
+	if err != nil {
+		p.logf("%s failed, restarting in %v: %v", p.Path, delay, waitErr)
+	} else {
+		p.logf("%s exited, restarting in %v", p.Path, delay)
+	}
+
+	p.restart = time.AfterFunc(delay, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.stopping {
+			// Stop couldn't cancel the timer, so it's left to us to end the run
+			p.end(nil)
+			return
+		}
+		if err := p.start(); err != nil {
+			p.end(fmt.Errorf("svc: restarting %s: %w", p.Path, err))
+		}
+	})
+}
+
+// exitError returns the *ExitError reporting how cmd failed.
+func (p *ProcessService) exitError(cmd *exec.Cmd, waitErr error) *ExitError {
+	state := cmd.ProcessState
+	if state == nil {
+		return &ExitError{Code: ExitSoftware, Err: fmt.Errorf("svc: %s: %w", p.Path, waitErr)}
+	}
+
+	code := state.ExitCode()
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		code = 128 + int(status.Signal())
+	}
+	return &ExitError{Code: code, Err: fmt.Errorf("svc: %s: %w", p.Path, waitErr)}
+}
+
+func (p *ProcessService) restartable(err *ExitError) bool {
+	switch p.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+// end reports that the command won't be started again until the next Start, with
+// err nil if it exited with status 0 or was stopped. Wait returns err; when the
+// service isn't supervised and wasn't stopped, err is also reported through Fail,
+// or a nil err ends the Context. p.mu must be held.
+func (p *ProcessService) end(err error) {
+	select {
+	case p.ended <- err:
+	default:
+	}
+	if p.stopping || p.supervised {
+		return
+	}
+
+	switch {
+	case err == nil:
+		if p.cancel != nil {
+			p.cancel()
+		}
+	case p.env == nil:
+		// err already starts with "svc: "
+		p.logger().Printf("%v", err)
+	default:
+		p.env.Fail(err)
+	}
+}
+
+// Stop sends the command StopSignal, and kills it if it hasn't exited within
+// StopTimeout. It returns an error if the command had to be killed.
+func (p *ProcessService) Stop() error {
+	p.mu.Lock()
+	p.stopping = true
+	if p.restart != nil && p.restart.Stop() {
+		// the command had exited and was waiting to be restarted
+		p.end(nil)
+	}
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	sig := p.StopSignal
+	if sig == nil {
+		sig = syscall.SIGTERM
+	}
+	timeout := p.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultProcessStopTimeout
+	}
+
+	signalErr := cmd.Process.Signal(sig)
+	if signalErr == nil {
+		select {
+		case <-exited:
+			return nil
+		case <-time.After(timeout):
+		}
+	}
+
+	// the command ignored the signal, couldn't be sent one, or already exited
+	select {
+	case <-exited:
+		return nil
+	default:
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		select {
+		case <-exited:
+			return nil
+		default:
+			return fmt.Errorf("svc: killing %s: %w", p.Path, err)
+		}
+	}
+	<-exited
+	if signalErr != nil {
+		// killing is the only way to stop a command on Windows
+		return nil
+	}
+	return fmt.Errorf("svc: %s didn't exit within %v of %v, killed", p.Path, timeout, sig)
+}
+
+func (p *ProcessService) logger() Logger {
+	if p.Logger == nil {
+		return stdLogger{}
+	}
+	return p.Logger
+}
+
+func (p *ProcessService) logf(format string, v ...interface{}) {
+	p.logger().Printf("svc: "+format, v...)
+}
@@ -0,0 +1,268 @@
+// +build !windows
+
+package svc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessServiceExit(t *testing.T) {
+	// arrange
+	var stdout bytes.Buffer
+	p := &ProcessService{
+		Path:   "sh",
+		Args:   []string{"-c", "echo hello; exit 3"},
+		Stdout: &stdout,
+	}
+
+	// act
+	res, err := RunContext(context.Background(), p)
+
+	// assert
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("RunContext, want: *ExitError got: %v", err)
+	}
+	if exitErr.Code != 3 {
+		t.Errorf("Code, want: 3 got: %d", exitErr.Code)
+	}
+	if want := "svc: sh: exit status 3"; err.Error() != want {
+		t.Errorf("error, want: %q got: %q", want, err.Error())
+	}
+	if res.Cause != CauseFailure {
+		t.Errorf("Cause, want: %v got: %v", CauseFailure, res.Cause)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("stdout, want: %q got: %q", "hello\n", stdout.String())
+	}
+}
+
+func TestProcessServiceExitOK(t *testing.T) {
+	// arrange
+	p := &ProcessService{
+		Path: "sh",
+		Args: []string{"-c", "exit 0"},
+	}
+
+	// act
+	res, err := RunContext(context.Background(), p)
+
+	// assert
+	if err != nil {
+		t.Errorf("RunContext, want: <nil> got: %v", err)
+	}
+	if res.Cause != CauseContext {
+		t.Errorf("Cause, want: %v got: %v", CauseContext, res.Cause)
+	}
+}
+
+func TestProcessServiceSupervised(t *testing.T) {
+	cases := []struct {
+		name string
+		code int
+		runs int
+		log  []string
+	}{
+		{name: "completed", code: 0, runs: 1},
+		{name: "failed", code: 3, runs: 2, log: []string{
+			"worker failed, restarting in",
+			"worker failed, giving up after 1 restarts in 1m0s: svc: sh: exit status 3",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			path := filepath.Join(t.TempDir(), "runs")
+			p := &ProcessService{
+				Path: "sh",
+				Args: []string{"-c", fmt.Sprintf("echo run >> %s; exit %d", path, c.code)},
+			}
+			logger := make(chanLogger, 10)
+			s := &Supervisor{MaxRestarts: 1, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Logger: logger}
+			s.Add("worker", p, RestartOnFailure)
+
+			if err := s.Init(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// act
+			if err := s.Start(); err != nil {
+				t.Fatal(err)
+			}
+			// a failing command is restarted once, then the Supervisor gives up
+			var messages []string
+			timeout := time.After(200 * time.Millisecond)
+		wait:
+			for {
+				select {
+				case msg := <-logger:
+					messages = append(messages, msg)
+					if strings.Contains(msg, "giving up") {
+						break wait
+					}
+				case <-timeout:
+					break wait
+				}
+			}
+			err := s.Stop()
+
+			// assert
+			if err != nil {
+				t.Errorf("Stop: %v", err)
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runs := strings.Count(string(b), "run\n"); runs != c.runs {
+				t.Errorf("runs, want: %d got: %d", c.runs, runs)
+			}
+			if len(messages) != len(c.log) {
+				t.Fatalf("log, want: %q got: %q", c.log, messages)
+			}
+			for i, msg := range messages {
+				if !strings.Contains(msg, c.log[i]) {
+					t.Errorf("log, want: ...%q... got: %q", c.log[i], msg)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessServiceStop(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{name: "graceful", script: "trap 'exit 0' TERM; echo ready; while :; do sleep 0.01; done"},
+		{name: "killed", script: "trap '' TERM; echo ready; while :; do sleep 0.01; done", want: "svc: sh didn't exit within 100ms of terminated, killed"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			r, w := ioPipe(t)
+			p := &ProcessService{
+				Path:        "sh",
+				Args:        []string{"-c", c.script},
+				Stdout:      w,
+				StopTimeout: 100 * time.Millisecond,
+			}
+			if err := p.Init(nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Start(); err != nil {
+				t.Fatal(err)
+			}
+			waitLine(t, r, "ready")
+
+			// act
+			err := p.Stop()
+
+			// assert
+			if c.want == "" && err != nil {
+				t.Errorf("Stop: %v", err)
+			}
+			if c.want != "" && (err == nil || err.Error() != c.want) {
+				t.Errorf("Stop, want: %q got: %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestProcessServiceRestart(t *testing.T) {
+	// arrange
+	path := filepath.Join(t.TempDir(), "runs")
+	logger := make(chanLogger)
+	p := &ProcessService{
+		Path:         "sh",
+		Args:         []string{"-c", "echo run >> " + path + "; exit 1"},
+		Restart:      RestartOnFailure,
+		RestartDelay: time.Millisecond,
+		Logger:       logger,
+	}
+	if err := p.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for len(messages) < 3 {
+		select {
+		case msg := <-logger:
+			messages = append(messages, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for restart %d", len(messages)+1)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-logger:
+			case <-stopped:
+				return
+			}
+		}
+	}()
+	err := p.Stop()
+	close(stopped)
+
+	// assert
+	if err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	for _, msg := range messages {
+		if want := "svc: sh failed, restarting in 1ms: exit status 1"; msg != want {
+			t.Errorf("log, want: %q got: %q", want, msg)
+		}
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(b), "run\n"); runs < 3 {
+		t.Errorf("runs, want: at least 3 got: %d", runs)
+	}
+}
+
+// ioPipe returns a pipe closed when the test ends, for reading a command's output.
+func ioPipe(t *testing.T) (*bufio.Reader, *os.File) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, r)
+	closeOnCleanup(t, w)
+	return bufio.NewReader(r), w
+}
+
+func waitLine(t *testing.T, r *bufio.Reader, want string) {
+	t.Helper()
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != want+"\n" {
+		t.Fatalf("output, want: %q got: %q", want+"\n", line)
+	}
+}
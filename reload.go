@@ -0,0 +1,9 @@
+package svc
+
+// reloadService calls the Reloader's Reload method. A failed reload is logged
+// and otherwise ignored so the service keeps running with its old configuration.
+func reloadService(r Reloader, o *options) {
+	if err := o.protect(PhaseRun, r.Reload); err != nil {
+		o.logf("reload failed: %v", err)
+	}
+}
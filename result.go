@@ -0,0 +1,145 @@
+package svc
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// A Phase is a step in the lifecycle of a Service run by RunContext.
+type Phase int
+
+const (
+	// PhaseInit is the call to the Service's Init method.
+	PhaseInit Phase = iota + 1
+	// PhaseStart is the call to the Service's Start method.
+	PhaseStart
+	// PhaseRun is the time between Start returning and the service being told to stop.
+	PhaseRun
+	// PhaseStop is the call to the Service's Stop method.
+	PhaseStop
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseInit:
+		return "init"
+	case PhaseStart:
+		return "start"
+	case PhaseRun:
+		return "run"
+	case PhaseStop:
+		return "stop"
+	default:
+		return "unknown"
+	}
+}
+
+// A Cause is the reason a running service was stopped.
+type Cause int
+
+const (
+	// CauseNone means the service never ran because Init or Start failed.
+	CauseNone Cause = iota
+	// CauseSignal means a signal whose action is ActionStop was received.
+	CauseSignal
+	// CauseServiceControl means the Windows SCM asked the service to stop or shut down.
+	CauseServiceControl
+	// CauseContext means the context passed to RunContext, or the one returned by
+	// the Service's Context method, is done.
+	CauseContext
+	// CauseError means Run failed while the service was running, for example because
+	// systemd couldn't be notified.
+	CauseError
+	// CauseWatchdog means the Service's Health method reported an error while running
+	// under the systemd watchdog. See HealthChecker.
+	CauseWatchdog
+	// CauseFailure means the service reported an error through the Environment's
+	// Fail or Go methods.
+	CauseFailure
+	// CauseUpgrade means a new copy of the program, started for a signal whose action
+	// is ActionUpgrade, reported that it's ready to take over.
+	CauseUpgrade
+)
+
+func (c Cause) String() string {
+	switch c {
+	case CauseNone:
+		return "none"
+	case CauseSignal:
+		return "signal"
+	case CauseServiceControl:
+		return "service control"
+	case CauseContext:
+		return "context done"
+	case CauseError:
+		return "error"
+	case CauseWatchdog:
+		return "watchdog"
+	case CauseFailure:
+		return "failure"
+	case CauseUpgrade:
+		return "upgrade"
+	default:
+		return "unknown"
+	}
+}
+
+// Result describes how a service run by RunContext ended.
+type Result struct {
+	// Phase is the last phase entered. It's PhaseInit or PhaseStart when the
+	// corresponding method failed, and PhaseStop once the service was stopped.
+	Phase Phase
+
+	// Cause is why the service was stopped.
+	Cause Cause
+
+	// Signal is the signal which stopped the service when Cause is CauseSignal or
+	// CauseUpgrade.
+	Signal os.Signal
+
+	// Err is the error which stopped the service: the context's error when Cause
+	// is CauseContext, the underlying error for CauseError and CauseWatchdog, and
+	// the error passed to the Environment's Fail method for CauseFailure.
+	Err error
+
+	// StopErr is the error returned by the Service's Stop method, or a *TimeoutError.
+	StopErr error
+
+	// InitDuration, StartDuration, RunDuration and StopDuration are the time spent in each phase.
+	InitDuration  time.Duration
+	StartDuration time.Duration
+	RunDuration   time.Duration
+	StopDuration  time.Duration
+}
+
+// err returns the error RunContext returns for a service which was stopped: the
+// error from Stop if there was one, otherwise the error which caused the stop
+// unless the service was asked to stop.
+func (r *Result) err() error {
+	if r.StopErr != nil {
+		return r.StopErr
+	}
+	switch r.Cause {
+	case CauseError, CauseWatchdog, CauseFailure:
+		return r.Err
+	}
+	return nil
+}
+
+// timed calls fn, recording how long it took in d.
+func timed(d *time.Duration, fn func() error) error {
+	begin := time.Now()
+	err := fn()
+	*d = time.Since(begin)
+	return err
+}
+
+// serviceContext returns the context returned by the Service's Context method,
+// or context.Background() if the Service doesn't implement Context.
+func serviceContext(service Service) context.Context {
+	if s, ok := service.(Context); ok {
+		return s.Context()
+	}
+	return context.Background()
+}
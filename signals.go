@@ -0,0 +1,117 @@
+package svc
+
+import (
+	"fmt"
+	"os"
+)
+
+// A SignalAction is what Run does when it receives a signal. See WithSignalActions.
+type SignalAction struct {
+	kind signalActionKind
+	// fn is a pointer to keep SignalAction values comparable
+	fn *func(os.Signal)
+}
+
+type signalActionKind int
+
+const (
+	actionStop signalActionKind = iota
+	actionReload
+	actionReopenLogs
+	actionDumpGoroutines
+	actionUpgrade
+	actionFunc
+)
+
+var (
+	// ActionStop stops the service. It's the action for the signals passed to Run.
+	ActionStop = SignalAction{kind: actionStop}
+
+	// ActionReload calls the Service's Reload method. See Reloader.
+	ActionReload = SignalAction{kind: actionReload}
+
+	// ActionReopenLogs calls the Service's ReopenLogs method. See LogReopener.
+	ActionReopenLogs = SignalAction{kind: actionReopenLogs}
+
+	// ActionDumpGoroutines writes the stacks of all goroutines to os.Stderr,
+	// or the file set with WithStackDumpFile, and keeps the service running.
+	ActionDumpGoroutines = SignalAction{kind: actionDumpGoroutines}
+
+	// ActionUpgrade starts a new copy of the program's executable, handing it the
+	// listeners opened with the Environment's Listen method, and stops the service
+	// once the new copy reports it's ready, so the listeners are never closed. If the
+	// new copy fails to start the service keeps running, and if the service stops for
+	// another reason first the new copy is sent SIGTERM. It isn't supported on Windows.
+	ActionUpgrade = SignalAction{kind: actionUpgrade}
+)
+
+// ActionFunc returns a SignalAction which calls fn with the received signal
+// and keeps the service running. fn is called on the goroutine running Run,
+// so signals are handled one at a time.
+func ActionFunc(fn func(os.Signal)) SignalAction {
+	return SignalAction{kind: actionFunc, fn: &fn}
+}
+
+// signalActions returns the action for each signal Run handles: the stop signals,
+// the reload signals if the service implements Reloader, and the actions set with
+// WithSignalActions, in increasing order of precedence.
+func signalActions(service Service, o *options, stopSignals []os.Signal) map[os.Signal]SignalAction {
+	actions := make(map[os.Signal]SignalAction)
+	for _, sig := range stopSignals {
+		actions[sig] = ActionStop
+	}
+	if _, ok := service.(Reloader); ok {
+		for _, sig := range o.reloadSignals {
+			actions[sig] = ActionReload
+		}
+	}
+	for sig, action := range o.signalActions {
+		actions[sig] = action
+	}
+	return actions
+}
+
+// notifySignals registers c to receive the signals in actions.
+func notifySignals(c chan<- os.Signal, actions map[os.Signal]SignalAction) {
+	signals := make([]os.Signal, 0, len(actions))
+	for sig := range actions {
+		signals = append(signals, sig)
+	}
+	signalNotify(c, signals...)
+}
+
+// handleSignal performs the action for sig and reports whether the service should
+// be stopped. reload is called for ActionReload.
+func handleSignal(service Service, o *options, action SignalAction, sig os.Signal, reload func(Reloader)) bool {
+	switch action.kind {
+	case actionStop:
+		return true
+	case actionReload:
+		r, ok := service.(Reloader)
+		if !ok {
+			o.logf("received %v but the service doesn't implement Reloader", sig)
+			return false
+		}
+		reload(r)
+	case actionReopenLogs:
+		lr, ok := service.(LogReopener)
+		if !ok {
+			o.logf("received %v but the service doesn't implement LogReopener", sig)
+			return false
+		}
+		if err := lr.ReopenLogs(); err != nil {
+			o.logf("reopening logs failed: %v", err)
+		}
+	case actionDumpGoroutines:
+		header := fmt.Sprintf("svc: received %v, goroutine stacks:\n\n", sig)
+		if err := dumpStacks(o.stackDumpPath, header); err != nil {
+			o.logf("writing goroutine stacks failed: %v", err)
+		}
+	case actionUpgrade:
+		// RunContext handles upgrades where they're supported
+		o.logf("received %v but upgrades aren't supported on this platform", sig)
+	case actionFunc:
+		(*action.fn)(sig)
+	}
+	return false
+}
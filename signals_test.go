@@ -0,0 +1,81 @@
+package svc
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSignalActions(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	reloader := &reloadProgram{mockProgram: prg}
+
+	opts := testOptions(t,
+		WithReloadSignals(syscall.SIGHUP),
+		WithSignalActions(map[os.Signal]SignalAction{
+			syscall.SIGTERM: ActionDumpGoroutines,
+		}),
+	)
+	stopSignals := []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+	// act
+	actions := signalActions(prg, opts, stopSignals)
+	reloaderActions := signalActions(reloader, opts, stopSignals)
+
+	// assert
+	want := map[os.Signal]SignalAction{
+		syscall.SIGINT:  ActionStop,
+		syscall.SIGTERM: ActionDumpGoroutines,
+		syscall.SIGHUP:  ActionStop,
+	}
+	for sig, action := range want {
+		if actions[sig] != action {
+			t.Errorf("%v, want: %v got: %v", sig, action.kind, actions[sig].kind)
+		}
+	}
+	if len(actions) != len(want) {
+		t.Errorf("len(actions), want: %d got: %d", len(want), len(actions))
+	}
+
+	if reloaderActions[syscall.SIGHUP] != ActionReload {
+		t.Errorf("SIGHUP with Reloader, want: %v got: %v", actionReload, reloaderActions[syscall.SIGHUP].kind)
+	}
+}
+
+func TestStopServiceForceExitIgnoresOtherActions(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	prg.stop = func() error {
+		<-release
+		return nil
+	}
+
+	osExit = func(code int) {
+		t.Errorf("osExit(%d) called for a signal which doesn't stop the service", code)
+	}
+	defer func() {
+		osExit = os.Exit
+	}()
+
+	signals := make(chan os.Signal)
+	go func() {
+		signals <- syscall.SIGQUIT
+		close(release)
+	}()
+
+	// act
+	err := stopService(prg, testOptions(t, WithForceExit(1)), nil, signals, map[os.Signal]SignalAction{
+		syscall.SIGINT:  ActionStop,
+		syscall.SIGQUIT: ActionReopenLogs,
+	})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,164 @@
+package svc
+
+import (
+	"sync"
+	"time"
+)
+
+// A State is a step in the lifecycle of a service run by Run, as reported by the
+// Environment's State method.
+type State int
+
+const (
+	// StateInitializing means Init is being called.
+	StateInitializing State = iota + 1
+	// StateStarting means Start is being called.
+	StateStarting
+	// StateRunning means Start has returned and the service hasn't been told to stop.
+	StateRunning
+	// StateReloading means the running service's Reload method is being called.
+	StateReloading
+	// StateStopping means Stop is being called.
+	StateStopping
+	// StateStopped means the service stopped and Run is returning without an error.
+	StateStopped
+	// StateFailed means Run is returning an error, because Init or Start failed or
+	// because of the way the service stopped.
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateInitializing:
+		return "initializing"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateReloading:
+		return "reloading"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	case StateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// terminal reports whether s is the last state of a run.
+func (s State) terminal() bool {
+	return s == StateStopped || s == StateFailed
+}
+
+// stateTransitions lists the states each state can change to.
+var stateTransitions = map[State][]State{
+	StateInitializing: {StateStarting, StateFailed},
+	StateStarting:     {StateRunning, StateFailed},
+	StateRunning:      {StateReloading, StateStopping},
+	StateReloading:    {StateRunning},
+	StateStopping:     {StateStopped, StateFailed},
+}
+
+// A StateChange is a transition of the service from one State to another,
+// delivered to the channels returned by the Environment's Subscribe method.
+type StateChange struct {
+	From State
+	To   State
+	// Time is when the service entered To.
+	Time time.Time
+}
+
+// subscriberBuffer is the capacity of the channels returned by Subscribe, enough
+// for every transition of a run without reloads.
+const subscriberBuffer = 16
+
+// states tracks the State of a service and notifies the subscribers to its changes.
+type states struct {
+	mu          sync.Mutex
+	state       State
+	subscribers []chan StateChange
+}
+
+func newStates() *states {
+	return &states{state: StateInitializing}
+}
+
+// State returns the current State.
+func (s *states) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Subscribe returns a channel receiving each StateChange, closed once the service
+// is stopped or failed.
+func (s *states) Subscribe() <-chan StateChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := make(chan StateChange, subscriberBuffer)
+	if s.state.terminal() {
+		close(c)
+		return c
+	}
+	s.subscribers = append(s.subscribers, c)
+	return c
+}
+
+// transition changes the State to to, returning the State it changed from and
+// false if the change isn't allowed. A subscriber whose channel is full misses
+// the change rather than holding up Run.
+func (s *states) transition(to State) (State, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := s.state
+	if !validTransition(from, to) {
+		return from, false
+	}
+
+	change := StateChange{From: from, To: to, Time: time.Now()}
+	s.state = to
+	for _, c := range s.subscribers {
+		select {
+		case c <- change:
+		default:
+		}
+		if to.terminal() {
+			close(c)
+		}
+	}
+	if to.terminal() {
+		s.subscribers = nil
+	}
+	return from, true
+}
+
+func validTransition(from, to State) bool {
+	for _, s := range stateTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setState changes the State, logging a rejected transition: Run only makes the
+// transitions the lifecycle allows.
+func (s *states) setState(to State, o *options) {
+	if from, ok := s.transition(to); !ok {
+		o.logf("invalid state transition from %v to %v", from, to)
+	}
+}
+
+// finish moves the State to StateFailed if err isn't nil, otherwise StateStopped.
+func (s *states) finish(err error, o *options) {
+	to := StateStopped
+	if err != nil {
+		to = StateFailed
+	}
+	s.setState(to, o)
+}
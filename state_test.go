@@ -0,0 +1,99 @@
+package svc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatesTransition(t *testing.T) {
+	// arrange
+	s := newStates()
+	c := s.Subscribe()
+
+	// act
+	var rejected []State
+	for _, to := range []State{StateStarting, StateRunning, StateReloading, StateRunning, StateStopping, StateStopped} {
+		if _, ok := s.transition(to); !ok {
+			rejected = append(rejected, to)
+		}
+	}
+	from, afterStop := s.transition(StateRunning)
+
+	// assert
+	if len(rejected) != 0 {
+		t.Errorf("rejected transitions to %v", rejected)
+	}
+
+	var got []StateChange
+	for change := range c {
+		if change.Time.IsZero() {
+			t.Errorf("%v to %v, want: time got: zero", change.From, change.To)
+		}
+		got = append(got, StateChange{From: change.From, To: change.To})
+	}
+	want := []StateChange{
+		{From: StateInitializing, To: StateStarting},
+		{From: StateStarting, To: StateRunning},
+		{From: StateRunning, To: StateReloading},
+		{From: StateReloading, To: StateRunning},
+		{From: StateRunning, To: StateStopping},
+		{From: StateStopping, To: StateStopped},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("changes, want: %v got: %v", want, got)
+	}
+
+	if afterStop || from != StateStopped {
+		t.Errorf("transition after stopping, want: stopped false got: %v %v", from, afterStop)
+	}
+	if s.State() != StateStopped {
+		t.Errorf("State, want: %v got: %v", StateStopped, s.State())
+	}
+	if _, ok := <-s.Subscribe(); ok {
+		t.Error("Subscribe after stopping, want: closed channel")
+	}
+}
+
+func TestStatesInvalidTransition(t *testing.T) {
+	cases := []struct {
+		from, to State
+	}{
+		{from: StateInitializing, to: StateRunning},
+		{from: StateStarting, to: StateStopping},
+		{from: StateRunning, to: StateStarting},
+		{from: StateReloading, to: StateStopping},
+		{from: StateStopping, to: StateRunning},
+		{from: StateFailed, to: StateStopped},
+	}
+
+	for _, c := range cases {
+		// arrange
+		s := &states{state: c.from}
+
+		// act
+		from, ok := s.transition(c.to)
+
+		// assert
+		if ok || from != c.from {
+			t.Errorf("%v to %v, want: %v false got: %v %v", c.from, c.to, c.from, from, ok)
+		}
+		if s.State() != c.from {
+			t.Errorf("%v to %v, State want: %v got: %v", c.from, c.to, c.from, s.State())
+		}
+	}
+}
+
+func TestStatesSetStateInvalid(t *testing.T) {
+	// arrange
+	s := &states{state: StateStopped}
+	logger := &recordingLogger{}
+
+	// act
+	s.setState(StateRunning, testOptions(t, WithLogger(logger)))
+
+	// assert
+	want := []string{"svc: invalid state transition from stopped to running"}
+	if !reflect.DeepEqual(want, logger.messages) {
+		t.Errorf("log, want: %q got: %q", want, logger.messages)
+	}
+}
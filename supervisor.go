@@ -0,0 +1,433 @@
+package svc
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A Waiter is a Service whose work can end after Start has returned, for example
+// because a goroutine it started has failed. Wait blocks until the work ends and
+// returns nil if it completed, or the error it failed with.
+//
+// Wait is called once after each successful Start, and must return once Stop has
+// been called.
+type Waiter interface {
+	Wait() error
+}
+
+// A RestartPolicy says whether a Supervisor restarts a child whose work has ended.
+type RestartPolicy int
+
+const (
+	// RestartAlways restarts the child whether it failed or completed.
+	RestartAlways RestartPolicy = iota + 1
+	// RestartOnFailure restarts the child only if Wait returned an error.
+	RestartOnFailure
+	// RestartNever leaves the child stopped.
+	RestartNever
+)
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartAlways:
+		return "always"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartNever:
+		return "never"
+	default:
+		return "unknown"
+	}
+}
+
+// Default backoff between restarts of a Supervisor's child.
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// A Supervisor runs several Services as one Service, restarting children which
+// fail while the Supervisor is running.
+//
+// Children are initialized and started in the order they were added and stopped
+// in reverse order. A child reports a failure by calling Fail, or returning an
+// error from a function passed to Go, on the Environment it was initialized with;
+// the Supervisor stops the child before restarting it. A child implementing Waiter
+// also reports that its work has ended, with or without an error, by Wait
+// returning.
+//
+// Depending on its RestartPolicy a failed or ended child is started again after
+// a backoff, which doubles with each restart within Window, from MinBackoff up to
+// MaxBackoff, with random jitter so children failing together don't restart
+// together. Init isn't called again.
+//
+// If a child is restarted more than MaxRestarts times within Window the Supervisor
+// gives up and reports a *RestartLimitError through its own Environment's Fail
+// method, so Run stops the process and returns the error.
+//
+// The zero value is an empty Supervisor with no restart limit, ready to use.
+type Supervisor struct {
+	// MaxRestarts is the number of restarts allowed within Window. Zero means no limit.
+	MaxRestarts int
+	// Window is the period restarts are counted over, for both MaxRestarts and the
+	// backoff. Zero means one minute.
+	Window time.Duration
+	// MinBackoff and MaxBackoff bound the delay before restarting a child.
+	// Zero means DefaultMinBackoff and DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Logger receives restart messages. Nil means the log package's standard logger.
+	Logger Logger
+
+	children []*supervisedChild
+	env      Environment
+
+	mu       sync.Mutex
+	stopping bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type supervisedChild struct {
+	name     string
+	service  Service
+	policy   RestartPolicy
+	failed   chan error
+	restarts []time.Time
+	// goroutines tracks the functions started with the Environment's Go method
+	goroutines sync.WaitGroup
+
+	// mu guards running and waited, and is held while the child is started or stopped
+	mu      sync.Mutex
+	running bool
+	// waited delivers the result of Wait for the current run if the child is a Waiter
+	waited chan error
+}
+
+// childEnvironment is the Environment passed to a Supervisor's children, reporting
+// their failures to the Supervisor instead of Run.
+type childEnvironment struct {
+	Environment
+	child *supervisedChild
+}
+
+func (e *childEnvironment) Fail(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case e.child.failed <- err:
+	default:
+	}
+}
+
+func (e *childEnvironment) Go(fn func() error) {
+	e.child.goroutines.Add(1)
+	go func() {
+		defer e.child.goroutines.Done()
+		e.Fail(fn())
+	}()
+}
+
+// A RestartLimitError is reported by a Supervisor when a child was restarted more
+// often than its MaxRestarts allows.
+type RestartLimitError struct {
+	// Name is the child's name.
+	Name string
+	// Restarts is the number of restarts allowed within Window.
+	Restarts int
+	Window   time.Duration
+	// Err is the error the child last failed with, if any.
+	Err error
+}
+
+func (e *RestartLimitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("svc: %s: more than %d restarts in %v", e.Name, e.Restarts, e.Window)
+	}
+	return fmt.Sprintf("svc: %s: more than %d restarts in %v, last error: %v", e.Name, e.Restarts, e.Window, e.Err)
+}
+
+func (e *RestartLimitError) Unwrap() error {
+	return e.Err
+}
+
+// Add adds service to the Supervisor with the given restart policy. name identifies
+// the child in errors and log messages. Add must not be called once the Supervisor
+// has been passed to Run.
+func (s *Supervisor) Add(name string, service Service, policy RestartPolicy) {
+	s.children = append(s.children, &supervisedChild{
+		name:    name,
+		service: service,
+		policy:  policy,
+		failed:  make(chan error, 1),
+	})
+}
+
+// Init calls Init on each child in order, stopping at the first error.
+func (s *Supervisor) Init(env Environment) error {
+	s.env = env
+	for _, c := range s.children {
+		if err := c.service.Init(&childEnvironment{Environment: env, child: c}); err != nil {
+			return &MemberError{Name: c.name, Phase: PhaseInit, Err: err}
+		}
+	}
+	return nil
+}
+
+// Start starts the children in order and begins supervising them. If a child fails
+// to start, the children already started are stopped in reverse order.
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	s.stopping = false
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+
+	for i, c := range s.children {
+		if err := s.startChild(c); err != nil {
+			errs := MultiError{&MemberError{Name: c.name, Phase: PhaseStart, Err: err}}
+			for j := i - 1; j >= 0; j-- {
+				if stopErr := s.stopChild(s.children[j]); stopErr != nil {
+					errs = append(errs, &MemberError{Name: s.children[j].name, Phase: PhaseStop, Err: stopErr})
+				}
+			}
+			s.wg.Wait()
+			return errs.err()
+		}
+	}
+
+	for _, c := range s.children {
+		s.wg.Add(1)
+		go s.supervise(c, s.done)
+	}
+	return nil
+}
+
+// Stop stops the running children in reverse order, returning their errors. As
+// Run does, it then waits for the functions the children started with Go.
+func (s *Supervisor) Stop() error {
+	s.mu.Lock()
+	if !s.stopping && s.done != nil {
+		close(s.done)
+	}
+	s.stopping = true
+	s.mu.Unlock()
+
+	var errs MultiError
+	for i := len(s.children) - 1; i >= 0; i-- {
+		c := s.children[i]
+		if err := s.stopChild(c); err != nil {
+			errs = append(errs, &MemberError{Name: c.name, Phase: PhaseStop, Err: err})
+		}
+	}
+
+	s.wg.Wait()
+	for _, c := range s.children {
+		c.goroutines.Wait()
+	}
+
+	return errs.err()
+}
+
+func (s *Supervisor) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopping
+}
+
+// errStopping is returned by startChild once the Supervisor is stopping.
+var errStopping = errors.New("svc: supervisor stopping")
+
+// startChild starts c, discarding any failure reported by a previous run, and
+// calls its Wait method in a new goroutine if it's a Waiter.
+func (s *Supervisor) startChild(c *supervisedChild) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// checked with c.mu held so Stop either sees c running or we see it stopping
+	if s.isStopping() {
+		return errStopping
+	}
+
+	select {
+	case <-c.failed:
+	default:
+	}
+
+	if err := c.service.Start(); err != nil {
+		return err
+	}
+	c.running = true
+
+	c.waited = nil
+	if w, ok := c.service.(Waiter); ok {
+		waited := make(chan error, 1)
+		c.waited = waited
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			waited <- w.Wait()
+		}()
+	}
+	return nil
+}
+
+// stopChild stops c if it's running, and waits for the functions it started with
+// Go so a restarted child doesn't run alongside them.
+func (s *Supervisor) stopChild(c *supervisedChild) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.running {
+		return nil
+	}
+	c.running = false
+	err := c.service.Stop()
+	c.goroutines.Wait()
+	return err
+}
+
+// supervise waits for c to fail or end and restarts it according to its policy,
+// until the Supervisor is stopped or gives up.
+func (s *Supervisor) supervise(c *supervisedChild, done <-chan struct{}) {
+	defer s.wg.Done()
+
+	for {
+		c.mu.Lock()
+		waited := c.waited
+		c.mu.Unlock()
+
+		var err error
+		select {
+		case err = <-waited:
+			c.mu.Lock()
+			c.running = false
+			c.mu.Unlock()
+		case err = <-c.failed:
+			if s.isStopping() {
+				return
+			}
+			if stopErr := s.stopChild(c); stopErr != nil {
+				s.logf("%s: stopping failed child: %v", c.name, stopErr)
+			}
+		case <-done:
+			return
+		}
+
+		for {
+			if s.isStopping() {
+				return
+			}
+
+			if !c.restartable(err) {
+				if err != nil {
+					s.logf("%s failed, not restarting (policy %v): %v", c.name, c.policy, err)
+				}
+				return
+			}
+
+			delay, ok := s.backoff(c, time.Now())
+			if !ok {
+				s.giveUp(c, err)
+				return
+			}
+			if err != nil {
+				s.logf("%s failed, restarting in %v: %v", c.name, delay, err)
+			} else {
+				s.logf("%s completed, restarting in %v", c.name, delay)
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-done:
+				return
+			}
+
+			if err = s.startChild(c); err == nil {
+				break
+			} else if err == errStopping {
+				return
+			}
+			err = &MemberError{Name: c.name, Phase: PhaseStart, Err: err}
+		}
+	}
+}
+
+func (c *supervisedChild) restartable(err error) bool {
+	switch c.policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+// backoff records a restart of c at now and returns the delay before it, or false
+// if the restart would exceed MaxRestarts.
+func (s *Supervisor) backoff(c *supervisedChild, now time.Time) (time.Duration, bool) {
+	window := s.Window
+	if window == 0 {
+		window = time.Minute
+	}
+
+	recent := c.restarts[:0]
+	for _, t := range c.restarts {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	c.restarts = append(recent, now)
+
+	if s.MaxRestarts > 0 && len(c.restarts) > s.MaxRestarts {
+		return 0, false
+	}
+
+	min, max := s.MinBackoff, s.MaxBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+
+	delay := min
+	for i := 1; i < len(c.restarts) && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	// full delay at most, half of it at least
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
+
+func (s *Supervisor) giveUp(c *supervisedChild, err error) {
+	window := s.Window
+	if window == 0 {
+		window = time.Minute
+	}
+	limitErr := &RestartLimitError{Name: c.name, Restarts: s.MaxRestarts, Window: window, Err: err}
+	if err != nil {
+		s.logf("%s failed, giving up after %d restarts in %v: %v", c.name, s.MaxRestarts, window, err)
+	} else {
+		s.logf("%s completed, giving up after %d restarts in %v", c.name, s.MaxRestarts, window)
+	}
+
+	if s.env != nil {
+		s.env.Fail(limitErr)
+	}
+}
+
+func (s *Supervisor) logf(format string, v ...interface{}) {
+	logger := s.Logger
+	if logger == nil {
+		logger = stdLogger{}
+	}
+	logger.Printf("svc: "+format, v...)
+}
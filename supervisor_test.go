@@ -0,0 +1,288 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitProgram is a Waiter whose work fails with the errors sent on fail, and
+// completes when stopped.
+type waitProgram struct {
+	fail    chan error
+	started chan struct{}
+
+	mu      sync.Mutex
+	starts  int
+	stopped chan struct{}
+}
+
+func newWaitProgram() *waitProgram {
+	return &waitProgram{
+		fail:    make(chan error),
+		started: make(chan struct{}, 10),
+	}
+}
+
+func (p *waitProgram) Init(Environment) error {
+	return nil
+}
+
+func (p *waitProgram) Start() error {
+	p.mu.Lock()
+	p.starts++
+	p.stopped = make(chan struct{})
+	p.mu.Unlock()
+	p.started <- struct{}{}
+	return nil
+}
+
+func (p *waitProgram) Stop() error {
+	p.mu.Lock()
+	close(p.stopped)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *waitProgram) Wait() error {
+	p.mu.Lock()
+	stopped := p.stopped
+	p.mu.Unlock()
+
+	select {
+	case err := <-p.fail:
+		return err
+	case <-stopped:
+		return nil
+	}
+}
+
+func (p *waitProgram) startCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.starts
+}
+
+// chanLogger sends each message on the channel.
+type chanLogger chan string
+
+func (l chanLogger) Printf(format string, v ...interface{}) {
+	l <- fmt.Sprintf(format, v...)
+}
+
+func waitStarted(t *testing.T, p *waitProgram) {
+	t.Helper()
+	select {
+	case <-p.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("child not started")
+	}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	// arrange
+	worker := newWaitProgram()
+	s := &Supervisor{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Logger: make(chanLogger, 10)}
+	s.Add("worker", worker, RestartOnFailure)
+
+	if err := s.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, worker)
+
+	// act
+	worker.fail <- errors.New("connection lost")
+	waitStarted(t, worker)
+	err := s.Stop()
+
+	// assert
+	if err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if got := worker.startCount(); got != 2 {
+		t.Errorf("starts, want: 2 got: %d", got)
+	}
+}
+
+func TestSupervisorRestartNever(t *testing.T) {
+	// arrange
+	worker := newWaitProgram()
+	logger := make(chanLogger, 10)
+	s := &Supervisor{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Logger: logger}
+	s.Add("worker", worker, RestartNever)
+
+	if err := s.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, worker)
+
+	// act
+	worker.fail <- errors.New("connection lost")
+	var msg string
+	select {
+	case msg = <-logger:
+	case <-time.After(5 * time.Second):
+	}
+	err := s.Stop()
+
+	// assert
+	if err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if got := worker.startCount(); got != 1 {
+		t.Errorf("starts, want: 1 got: %d", got)
+	}
+	if want := "svc: worker failed, not restarting (policy never): connection lost"; msg != want {
+		t.Errorf("log, want: %q got: %q", want, msg)
+	}
+}
+
+func TestSupervisorRestartLimit(t *testing.T) {
+	// arrange
+	worker := newWaitProgram()
+	failErr := errors.New("connection lost")
+	s := &Supervisor{
+		MaxRestarts: 2,
+		Window:      time.Minute,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		Logger:      make(chanLogger, 10),
+	}
+	s.Add("worker", worker, RestartAlways)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			<-worker.started
+			worker.fail <- failErr
+		}
+	}()
+
+	// act
+	res, err := RunContext(context.Background(), s)
+
+	// assert
+	var limitErr *RestartLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("RunContext, want: *RestartLimitError got: %v", err)
+	}
+	if !errors.Is(err, failErr) {
+		t.Errorf("RunContext, want: %v got: %v", failErr, err)
+	}
+	if want := "svc: worker: more than 2 restarts in 1m0s, last error: connection lost"; limitErr.Error() != want {
+		t.Errorf("error, want: %q got: %q", want, limitErr.Error())
+	}
+	if res.Cause != CauseFailure {
+		t.Errorf("Cause, want: %v got: %v", CauseFailure, res.Cause)
+	}
+	if got := worker.startCount(); got != 3 {
+		t.Errorf("starts, want: 3 got: %d", got)
+	}
+}
+
+func TestSupervisorStopWaitsForGo(t *testing.T) {
+	// arrange
+	stopped := make(chan struct{})
+	returned := make(chan struct{})
+	child := &mockProgram{
+		init: func(env Environment) error {
+			env.Go(func() error {
+				<-stopped
+				time.Sleep(10 * time.Millisecond)
+				close(returned)
+				return nil
+			})
+			return nil
+		},
+		start: func() error {
+			return nil
+		},
+		stop: func() error {
+			close(stopped)
+			return nil
+		},
+	}
+
+	s := &Supervisor{}
+	s.Add("worker", child, RestartNever)
+
+	if err := s.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err := s.Stop()
+
+	// assert
+	if err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	select {
+	case <-returned:
+	default:
+		t.Error("Stop returned before the function started with Go")
+	}
+}
+
+func TestSupervisorChildFail(t *testing.T) {
+	// arrange
+	var env Environment
+	starts, stops := 0, 0
+	started := make(chan struct{}, 10)
+	child := &mockProgram{
+		init: func(e Environment) error {
+			env = e
+			return nil
+		},
+		start: func() error {
+			starts++
+			started <- struct{}{}
+			return nil
+		},
+		stop: func() error {
+			stops++
+			return nil
+		},
+	}
+
+	s := &Supervisor{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Logger: make(chanLogger, 10)}
+	s.Add("worker", child, RestartOnFailure)
+
+	if err := s.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// act
+	env.Go(func() error {
+		return errors.New("connection lost")
+	})
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("child not restarted")
+	}
+	err := s.Stop()
+
+	// assert
+	if err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if starts != 2 || stops != 2 {
+		t.Errorf("starts/stops, want: 2/2 got: %d/%d", starts, stops)
+	}
+}
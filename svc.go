@@ -9,6 +9,7 @@ Init and Start cannot block. Launch long-running code in a new Goroutine.
 Stop may block for a short amount of time to attempt clean shutdown.
 
 Call svc.Run() with a reference to your svc.Service implementation to start your program.
+Use svc.RunContext() to stop it with a context and to find out why it stopped.
 
 When running in console mode Ctrl+C is treated like a Stop Service signal.
 
@@ -18,12 +19,53 @@ package svc
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"os/signal"
+	"time"
 )
 
 // Create variable signal.Notify function so we can mock it in tests
 var signalNotify = signal.Notify
 
+// ErrStartTimeout matches the error returned by Run when the Service doesn't start
+// within the start timeout, using errors.Is. See WithStartTimeout.
+var ErrStartTimeout = errors.New("svc: timed out waiting for Start to return")
+
+// ErrStopTimeout matches the error returned by Run when the Service doesn't stop
+// within the stop timeout, using errors.Is. See WithStopTimeout.
+var ErrStopTimeout = errors.New("svc: timed out waiting for Stop to return")
+
+// ErrAlreadyRunning matches the error returned by Run when another instance of the
+// program holds its pidfile or instance name, using errors.Is. See WithPIDFile and
+// WithSingleInstance.
+var ErrAlreadyRunning = errors.New("svc: already running")
+
+// A TimeoutError is returned by Run when the Service's start or stop method doesn't
+// return within its timeout, whether or not the method was passed a context.
+type TimeoutError struct {
+	// Phase is PhaseStart or PhaseStop.
+	Phase Phase
+	// Timeout is the time the method was given.
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("svc: %s phase overran its %v deadline", e.Phase, e.Timeout)
+}
+
+// Is reports whether target is ErrStartTimeout or ErrStopTimeout for the matching Phase.
+func (e *TimeoutError) Is(target error) bool {
+	switch target {
+	case ErrStartTimeout:
+		return e.Phase == PhaseStart
+	case ErrStopTimeout:
+		return e.Phase == PhaseStop
+	}
+	return false
+}
+
 // Service interface contains Start and Stop methods which are called
 // when the service is started and stopped. The Init method is called
 // before the service is started, and after it's determined if the program
@@ -46,6 +88,28 @@ type Service interface {
 	Stop() error
 }
 
+// ContextStarter is an optional interface a Service can implement to be started
+// with a context. When implemented StartContext is called instead of Start.
+//
+// The context's deadline is the start timeout (see WithStartTimeout); without
+// one the context has no deadline. StartContext must be non-blocking, and must
+// return once the context is done: if it overruns the deadline Run returns a
+// *TimeoutError for PhaseStart.
+type ContextStarter interface {
+	StartContext(ctx context.Context) error
+}
+
+// ContextStopper is an optional interface a Service can implement to be stopped
+// with a context. When implemented StopContext is called instead of Stop.
+//
+// The context's deadline is the stop timeout (see WithStopTimeout), so the service
+// can tell how much of its shutdown budget is left; without one the context has
+// no deadline. If StopContext overruns the deadline Run returns a *TimeoutError
+// for PhaseStop.
+type ContextStopper interface {
+	StopContext(ctx context.Context) error
+}
+
 // Context interface contains an optional Context function which a Service can implement.
 // When implemented the context.Done() channel will be used in addition to signal handling
 // to exit a process.
@@ -53,9 +117,103 @@ type Context interface {
 	Context() context.Context
 }
 
+// Reloader is an optional interface a Service can implement to reload its
+// configuration while running.
+//
+// When implemented, receiving one of the reload signals (syscall.SIGHUP unless
+// changed with WithReloadSignals) calls Reload instead of stopping the service,
+// even if the signal was also passed to Run as a stop signal. On Windows Reload
+// is called when the service receives the SCM's ParamChange control.
+//
+// An error returned by Reload is logged and the service keeps running. Under
+// systemd, RELOADING=1 and READY=1 are sent before and after calling Reload.
+type Reloader interface {
+	Reload() error
+}
+
+// LogReopener is an optional interface a Service can implement to reopen its log
+// files, typically after they've been rotated. ReopenLogs is called for signals
+// mapped to ActionReopenLogs; an error it returns is logged and the service keeps
+// running.
+type LogReopener interface {
+	ReopenLogs() error
+}
+
+// HealthChecker is an optional interface a Service can implement to report whether
+// it's healthy.
+//
+// When running under a systemd watchdog (WatchdogSec= in the unit file) Health is
+// called before each keep-alive is sent. Keep-alives are only sent while Health
+// returns nil; once it returns an error systemd is asked to act on the watchdog
+// immediately, which by default restarts a hung process.
+type HealthChecker interface {
+	// Health returns nil if the service is healthy. The provided context is done
+	// once the result would arrive too late to keep the watchdog from firing.
+	Health(ctx context.Context) error
+}
+
 // Environment contains information about the environment
 // your application is running in.
 type Environment interface {
 	// IsWindowsService reports whether the program is running as a Windows Service.
 	IsWindowsService() bool
+
+	// Listeners returns the stream sockets passed to the program through socket
+	// activation (systemd .socket units), in the order they were passed. Entries
+	// for sockets which aren't stream listeners are nil.
+	Listeners() []net.Listener
+
+	// PacketConns returns the datagram sockets passed to the program through socket
+	// activation, in the order they were passed. Entries for sockets which aren't
+	// datagram sockets are nil.
+	PacketConns() []net.PacketConn
+
+	// ListenersWithName returns the stream sockets passed through socket activation
+	// whose FileDescriptorName= is name.
+	ListenersWithName(name string) []net.Listener
+
+	// PacketConnsWithName returns the datagram sockets passed through socket activation
+	// whose FileDescriptorName= is name.
+	PacketConnsWithName(name string) []net.PacketConn
+
+	// Listen returns a stream listener named name. If the process this one replaced
+	// with ActionUpgrade, or socket activation, passed a listener with that name it's
+	// returned, whatever its address; otherwise a new listener is opened on the given
+	// network and address as with net.Listen. The listeners returned by Listen are
+	// handed over to the new process when the program is upgraded. name can't contain
+	// a ':'.
+	Listen(name, network, address string) (net.Listener, error)
+
+	// Fail reports that the running service has failed with err. Run stops the
+	// service and returns err, with Result.Cause set to CauseFailure. Only the first
+	// error reported is kept; a nil error is ignored. Fail may be called from any
+	// goroutine, including during Start.
+	Fail(err error)
+
+	// Identity returns the effective user and groups the process runs as. After
+	// Init it reflects the user switched to with WithUser.
+	Identity() Identity
+
+	// IsContainerInit reports whether Run is acting as the init process of a
+	// container. See WithContainerInit.
+	IsContainerInit() bool
+
+	// PIDFile returns the path of the pidfile written by Run, or "" if there is none.
+	// See WithPIDFile.
+	PIDFile() string
+
+	// State returns the service's current State, for example so a health endpoint
+	// can report that the service is stopping.
+	State() State
+
+	// Subscribe returns a channel receiving a StateChange for each transition of the
+	// service's State, which is closed once the State is StateStopped or StateFailed.
+	// The channel is buffered; a change arriving while it's full is dropped rather
+	// than holding up Run, so receive from it promptly.
+	Subscribe() <-chan StateChange
+
+	// Go calls fn in a new goroutine, calling Fail with the error it returns, if any.
+	// Once Stop returns Run waits for the functions started with Go to return, so
+	// they must return once the service is stopped.
+	Go(fn func() error)
 }
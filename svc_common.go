@@ -4,48 +4,323 @@ package svc
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"syscall"
+	"time"
 )
 
+var defaultStopSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+var defaultReloadSignals = []os.Signal{syscall.SIGHUP}
+
 // Run runs your Service.
 //
 // Run will block until one of the signals specified in sig is received or a provided context is done.
 // If sig is empty syscall.SIGINT and syscall.SIGTERM are used by default.
+// Options may be passed in sig along with the signals to handle.
+//
+// Run is RunContext with context.Background(), returning only the error.
 func Run(service Service, sig ...os.Signal) error {
-	env := environment{}
-	if err := service.Init(env); err != nil {
-		return err
+	_, err := RunContext(context.Background(), service, signalOptions(sig)...)
+	return err
+}
+
+// RunContext runs your Service until one of the stop signals is received (see WithSignals),
+// ctx is done, or the context returned by the Service's Context method is done. The Result
+// describes why the service stopped and how long each phase took.
+//
+// The error returned is the error from Init or Start if either failed, otherwise the error
+// from Stop. If the service was stopped because of an error, for example a failed health
+// check (CauseWatchdog), that error is returned when Stop succeeds.
+//
+// When started by systemd with NOTIFY_SOCKET set (Type=notify units), RunContext sends
+// READY=1 and MAINPID once Start returns and STOPPING=1 before calling Stop.
+// If the systemd watchdog is enabled (WATCHDOG_USEC) RunContext also sends keep-alives
+// while the service is running; see HealthChecker.
+//
+// Sockets passed through socket activation (LISTEN_FDS) are made available
+// to Init through the Environment.
+//
+// If the Service implements Reloader, syscall.SIGHUP reloads the service
+// instead of stopping it. See WithReloadSignals and WithSignalActions.
+func RunContext(ctx context.Context, service Service, opts ...Option) (res Result, err error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return res, err
+	}
+
+	defer func() {
+		o.exit(res, err)
+	}()
+
+	// parent is the pipe to the process which started this one as a daemon or an
+	// upgrade, which waits for the service to start
+	parent, err := upgradeStarted()
+	if err != nil {
+		return res, err
+	}
+	// a daemon child's parent doesn't pass listeners, so it needs LISTEN_PID
+	upgradeChild := parent != nil
+	if o.daemon != nil && !upgradeChild {
+		if parent, err = daemonStarted(o.daemon); err != nil {
+			return res, err
+		}
+		if parent == nil {
+			if err := daemonize(o.daemon); err != nil {
+				return res, err
+			}
+			osExit(ExitOK)
+			// osExit only returns when mocked in tests
+			return res, nil
+		}
+	}
+	if parent != nil {
+		defer func() {
+			if reportErr := parent.report(err); reportErr != nil {
+				o.logf("reporting to parent process: %v", reportErr)
+			}
+		}()
+	}
+
+	var inst *instance
+	if o.instance != "" {
+		if inst, err = acquireInstance(o); err != nil {
+			return res, err
+		}
+		defer func() {
+			if err := inst.release(); err != nil {
+				o.logf("releasing instance name: %v", err)
+			}
+		}()
+	}
+
+	if o.pidFile != "" {
+		pid, err := lockPIDFile(o.pidFile, o)
+		if err != nil {
+			return res, err
+		}
+		defer func() {
+			if err := pid.unlock(); err != nil {
+				o.logf("removing pidfile: %v", err)
+			}
+		}()
+	}
+
+	ci, err := startContainerInit(o)
+	if err != nil {
+		return res, err
+	}
+	defer ci.stop()
+
+	watchdogInterval, err := sdWatchdogInterval()
+	if err != nil {
+		return res, err
+	}
+
+	sockets, err := sdListenFds(upgradeChild)
+	if err != nil {
+		return res, err
+	}
+
+	var identity *Identity
+	if o.user != "" {
+		id, err := lookupIdentity(o.user, o.group)
+		if err != nil {
+			return res, err
+		}
+		identity = &id
+	}
+
+	env := &environment{
+		activation:    sockets,
+		handoff:       newHandoff(sockets),
+		failures:      newFailures(),
+		pidFile:       o.pidFile,
+		containerInit: ci,
+		states:        newStates(),
+	}
+	defer func() {
+		env.finish(err, o)
+	}()
+
+	res.Phase = PhaseInit
+	if err := timed(&res.InitDuration, func() error { return initService(service, env, o) }); err != nil {
+		return res, err
+	}
+
+	if identity != nil {
+		if err := switchUser(*identity); err != nil {
+			return res, &ExitError{Code: ExitNoPerm, Err: err}
+		}
+	}
+
+	res.Phase = PhaseStart
+	env.setState(StateStarting, o)
+	if err := runStart(service, o, &res); err != nil {
+		return res, err
+	}
+	env.setState(StateRunning, o)
+
+	if err := sdNotify(sdReady()); err != nil {
+		// systemd fails a Type=notify unit which never reports
+		// readiness, so treat this the same as a failed start.
+		env.setState(StateStopping, o)
+		runStop(service, o, &res, env.failures, nil, nil)
+		if res.StopErr != nil {
+			return res, res.StopErr
+		}
+		return res, err
 	}
 
-	if err := service.Start(); err != nil {
-		return err
+	if err := parent.report(nil); err != nil {
+		o.logf("reporting to parent process: %v", err)
 	}
 
-	if len(sig) == 0 {
-		sig = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	actions := signalActions(service, o, o.stopSignals)
+
+	// a failed reload is logged and the service keeps running
+	reload := func(r Reloader) {
+		env.setState(StateReloading, o)
+		sdReload(r, o)
+		env.setState(StateRunning, o)
 	}
 
 	signalChan := make(chan os.Signal, 1)
-	signalNotify(signalChan, sig...)
+	notifySignals(signalChan, actions)
 
-	var ctx context.Context
-	if s, ok := service.(Context); ok {
-		ctx = s.Context()
-	} else {
-		ctx = context.Background()
+	svcCtx := serviceContext(service)
+
+	type stopReason struct {
+		cause Cause
+		err   error
+	}
+
+	done := make(chan struct{})
+	watchdogStop := make(chan stopReason, 1)
+	if watchdogInterval > 0 {
+		go func() {
+			cause, err := sdWatchdog(watchdogInterval, service, done)
+			watchdogStop <- stopReason{cause: cause, err: err}
+		}()
 	}
 
-	select {
-	case <-signalChan:
-	case <-ctx.Done():
+	type upgradeResult struct {
+		sig os.Signal
+		pid int
+		err error
+	}
+
+	// upgraded delivers the result of an upgrade in progress,
+	// which is canceled by closing cancelUpgrade
+	var upgraded chan upgradeResult
+	var cancelUpgrade chan struct{}
+	var upgradePID int
+
+	res.Phase = PhaseRun
+	begin := time.Now()
+	for res.Cause == CauseNone {
+		select {
+		case s := <-signalChan:
+			if actions[s] == ActionUpgrade {
+				if upgraded != nil {
+					o.logf("received %v but an upgrade is already in progress", s)
+					continue
+				}
+				upgraded, cancelUpgrade = make(chan upgradeResult, 1), make(chan struct{})
+				go func(c chan<- upgradeResult, cancel <-chan struct{}) {
+					pid, err := upgrade(env.handoff, cancel)
+					c <- upgradeResult{sig: s, pid: pid, err: err}
+				}(upgraded, cancelUpgrade)
+				continue
+			}
+			if handleSignal(service, o, actions[s], s, reload) {
+				res.Cause, res.Signal = CauseSignal, s
+				ci.forward(s)
+			}
+		case r := <-upgraded:
+			upgraded = nil
+			if r.err != nil {
+				o.logf("upgrade failed, still running: %v", r.err)
+				continue
+			}
+			res.Cause, res.Signal = CauseUpgrade, r.sig
+			upgradePID = r.pid
+		case <-ctx.Done():
+			res.Cause, res.Err = CauseContext, ctx.Err()
+		case <-svcCtx.Done():
+			res.Cause, res.Err = CauseContext, svcCtx.Err()
+		case r := <-watchdogStop:
+			res.Cause, res.Err = r.cause, r.err
+		case err := <-env.failed():
+			res.Cause, res.Err = CauseFailure, err
+		case <-inst.reloadRequests():
+			r, ok := service.(Reloader)
+			if !ok {
+				o.logf("another instance asked for a reload but the service doesn't implement Reloader")
+			} else {
+				reload(r)
+			}
+		}
 	}
+	res.RunDuration = time.Since(begin)
+	close(done)
 
-	return service.Stop()
+	if upgraded != nil {
+		// the service is stopping for another reason, so the new process mustn't
+		// take over
+		close(cancelUpgrade)
+		r := <-upgraded
+		switch {
+		case r.err == nil:
+			// it started before the cancel
+			if err := syscall.Kill(r.pid, syscall.SIGTERM); err != nil {
+				o.logf("canceling upgrade: %v", err)
+			}
+		case r.err != errUpgradeCanceled:
+			o.logf("upgrade failed: %v", r.err)
+		}
+	}
+
+	res.Phase = PhaseStop
+	env.setState(StateStopping, o)
+	var notifyErr error
+	if res.Cause == CauseUpgrade {
+		// the new process takes over as the unit's main process
+		notifyErr = sdNotify(fmt.Sprintf("MAINPID=%d", upgradePID))
+	} else {
+		notifyErr = sdNotify(sdStopping)
+	}
+
+	runStop(service, o, &res, env.failures, signalChan, actions)
+
+	if err := res.err(); err != nil {
+		return res, err
+	}
+	return res, notifyErr
 }
 
-type environment struct{}
+type environment struct {
+	activation
+	*handoff
+	*failures
+	*states
+	pidFile       string
+	containerInit *containerInit
+}
 
-func (environment) IsWindowsService() bool {
+func (*environment) IsWindowsService() bool {
 	return false
 }
+
+func (*environment) Identity() Identity {
+	return currentIdentity()
+}
+
+func (env *environment) IsContainerInit() bool {
+	return env.containerInit.isContainerInit()
+}
+
+func (env *environment) PIDFile() string {
+	return env.pidFile
+}
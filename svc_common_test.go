@@ -3,9 +3,15 @@
 package svc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"syscall"
 	"testing"
+	"time"
 )
 
 func TestDefaultSignalHandling(t *testing.T) {
@@ -34,6 +40,32 @@ func testSignalNotify(t *testing.T, signal os.Signal, sig ...os.Signal) {
 	var startCalled, stopCalled, initCalled int
 	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
 
+	mockSignalNotify(sigChan)
+
+	go func() {
+		sigChan <- signal
+	}()
+
+	// act
+	if err := Run(prg, sig...); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if startCalled != 1 {
+		t.Errorf("startCalled, want: 1 got: %d", startCalled)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+	if initCalled != 1 {
+		t.Errorf("initCalled, want: 1 got: %d", initCalled)
+	}
+}
+
+// mockSignalNotify replaces signalNotify so signals sent on sigChan are delivered
+// to the channel registered by Run, provided they match a registered signal.
+func mockSignalNotify(sigChan <-chan os.Signal) {
 	signalNotify = func(c chan<- os.Signal, sig ...os.Signal) {
 		if c == nil {
 			panic("os/signal: Notify using nil channel")
@@ -44,29 +76,494 @@ func testSignalNotify(t *testing.T, signal os.Signal, sig ...os.Signal) {
 				for _, registeredSig := range sig {
 					if val == registeredSig {
 						c <- val
+						break
 					}
 				}
 			}
 		}()
 	}
+}
+
+func TestRunStopTimeout(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.stop = func() error {
+		<-release
+		return nil
+	}
 
 	go func() {
-		sigChan <- signal
+		sigChan <- syscall.SIGTERM
 	}()
 
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+
 	// act
-	if err := Run(prg, sig...); err != nil {
+	err := Run(prg, WithStopTimeout(10*time.Millisecond), WithStackDumpFile(path))
+
+	// assert
+	if !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("Run, want: %v got: %v", ErrStopTimeout, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("stack dump: %v", err)
+	}
+}
+
+func TestRunForceExitOnSecondSignal(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	exitCode := make(chan int, 1)
+	osExit = func(code int) {
+		exitCode <- code
+	}
+	defer func() {
+		osExit = os.Exit
+	}()
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.stop = func() error {
+		<-release
+		return nil
+	}
+
+	go func() {
+		sigChan <- syscall.SIGINT
+		sigChan <- syscall.SIGINT
+	}()
+
+	// act
+	if err := Run(prg, WithForceExit(130)); err != nil {
 		t.Fatal(err)
 	}
 
 	// assert
-	if startCalled != 1 {
-		t.Errorf("startCalled, want: 1 got: %d", startCalled)
+	if code := <-exitCode; code != 130 {
+		t.Errorf("exit code, want: 130 got: %d", code)
+	}
+}
+
+func TestRunReload(t *testing.T) {
+	// arrange
+	conn := listenNotifySocket(t)
+
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled, reloadCalled int
+	prg := &reloadProgram{
+		mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled),
+		reload: func() error {
+			reloadCalled++
+			if reloadCalled == 2 {
+				return errors.New("reload error")
+			}
+			return nil
+		},
+	}
+
+	go func() {
+		sigChan <- syscall.SIGHUP
+		sigChan <- syscall.SIGHUP
+		sigChan <- syscall.SIGTERM
+	}()
+
+	// act
+	// SIGHUP is also passed as a stop signal, Reloader takes precedence
+	if err := Run(prg, syscall.SIGTERM, syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if reloadCalled != 2 {
+		t.Errorf("reloadCalled, want: 2 got: %d", reloadCalled)
 	}
 	if stopCalled != 1 {
 		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
 	}
-	if initCalled != 1 {
-		t.Errorf("initCalled, want: 1 got: %d", initCalled)
+
+	want := []string{
+		fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()),
+		"RELOADING=1",
+		"READY=1",
+		"RELOADING=1",
+		"READY=1",
+		"STOPPING=1",
+	}
+	for _, w := range want {
+		if got := readNotify(t, conn); got != w {
+			t.Errorf("notification, want: %q got: %q", w, got)
+		}
+	}
+}
+
+func TestRunReloadSignals(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled, reloadCalled int
+	prg := &reloadProgram{
+		mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled),
+		reload: func() error {
+			reloadCalled++
+			return nil
+		},
+	}
+
+	go func() {
+		sigChan <- syscall.SIGUSR1
+		sigChan <- syscall.SIGHUP
+	}()
+
+	// act
+	if err := Run(prg, syscall.SIGHUP, WithReloadSignals(syscall.SIGUSR1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if reloadCalled != 1 {
+		t.Errorf("reloadCalled, want: 1 got: %d", reloadCalled)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+func TestRunContextStates(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	var env Environment
+	var states []State
+	prg := &reloadProgram{
+		mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled),
+		reload: func() error {
+			states = append(states, env.State())
+			return nil
+		},
+	}
+	var changes <-chan StateChange
+	prg.init = func(e Environment) error {
+		env = e
+		changes = env.Subscribe()
+		states = append(states, env.State())
+		return nil
+	}
+	prg.start = func() error {
+		states = append(states, env.State())
+		go func() {
+			sigChan <- syscall.SIGHUP
+			sigChan <- syscall.SIGTERM
+		}()
+		return nil
+	}
+	prg.stop = func() error {
+		states = append(states, env.State())
+		return errors.New("stop failed")
+	}
+
+	// act
+	_, err := RunContext(context.Background(), prg)
+
+	// assert
+	if err == nil {
+		t.Fatal("RunContext, want: error got: <nil>")
+	}
+	want := []State{StateInitializing, StateStarting, StateReloading, StateStopping}
+	if !reflect.DeepEqual(want, states) {
+		t.Errorf("states, want: %v got: %v", want, states)
+	}
+
+	var got []State
+	for change := range changes {
+		got = append(got, change.To)
+	}
+	want = []State{StateStarting, StateRunning, StateReloading, StateRunning, StateStopping, StateFailed}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("changes, want: %v got: %v", want, got)
+	}
+	if env.State() != StateFailed {
+		t.Errorf("State, want: %v got: %v", StateFailed, env.State())
+	}
+}
+
+type reopenProgram struct {
+	*mockProgram
+	reopenCalled int
+}
+
+func (p *reopenProgram) ReopenLogs() error {
+	p.reopenCalled++
+	return nil
+}
+
+func TestRunSignalActions(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	prg := &reopenProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled)}
+
+	var custom []os.Signal
+
+	go func() {
+		sigChan <- syscall.SIGUSR1
+		sigChan <- syscall.SIGQUIT
+		sigChan <- syscall.SIGUSR2
+		sigChan <- syscall.SIGTERM
+	}()
+
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+
+	// act
+	err := Run(prg, WithStackDumpFile(path), WithSignalActions(map[os.Signal]SignalAction{
+		syscall.SIGUSR1: ActionReopenLogs,
+		syscall.SIGQUIT: ActionDumpGoroutines,
+		syscall.SIGUSR2: ActionFunc(func(sig os.Signal) {
+			custom = append(custom, sig)
+		}),
+	}))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prg.reopenCalled != 1 {
+		t.Errorf("reopenCalled, want: 1 got: %d", prg.reopenCalled)
+	}
+	if len(custom) != 1 || custom[0] != syscall.SIGUSR2 {
+		t.Errorf("custom action, want: [%v] got: %v", syscall.SIGUSR2, custom)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("stack dump: %v", err)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+func TestRunContextSignal(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.stop = func() error {
+		stopCalled++
+		time.Sleep(10 * time.Millisecond)
+		return errors.New("stop error")
+	}
+
+	go func() {
+		sigChan <- syscall.SIGUSR1
+	}()
+
+	// act
+	res, err := RunContext(context.Background(), prg, WithSignals(syscall.SIGUSR1))
+
+	// assert
+	if err == nil || err.Error() != "stop error" {
+		t.Fatalf("RunContext error, want: stop error got: %v", err)
+	}
+	if res.Phase != PhaseStop {
+		t.Errorf("Phase, want: %v got: %v", PhaseStop, res.Phase)
+	}
+	if res.Cause != CauseSignal {
+		t.Errorf("Cause, want: %v got: %v", CauseSignal, res.Cause)
+	}
+	if res.Signal != syscall.SIGUSR1 {
+		t.Errorf("Signal, want: %v got: %v", syscall.SIGUSR1, res.Signal)
+	}
+	if res.StopErr != err {
+		t.Errorf("StopErr, want: %v got: %v", err, res.StopErr)
+	}
+	if res.StopDuration < 10*time.Millisecond {
+		t.Errorf("StopDuration, want: >= 10ms got: %v", res.StopDuration)
+	}
+}
+
+func TestRunContextCanceled(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	// act
+	res, err := RunContext(ctx, prg)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cause != CauseContext {
+		t.Errorf("Cause, want: %v got: %v", CauseContext, res.Cause)
+	}
+	if res.Err != context.Canceled {
+		t.Errorf("Err, want: %v got: %v", context.Canceled, res.Err)
+	}
+	if res.RunDuration < 20*time.Millisecond {
+		t.Errorf("RunDuration, want: >= 20ms got: %v", res.RunDuration)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+func TestRunContextServiceContext(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	prg := &contextProgram{
+		mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled),
+		ctx:         ctx,
+	}
+
+	// act
+	res, err := RunContext(context.Background(), prg)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cause != CauseContext {
+		t.Errorf("Cause, want: %v got: %v", CauseContext, res.Cause)
+	}
+	if res.Err != context.DeadlineExceeded {
+		t.Errorf("Err, want: %v got: %v", context.DeadlineExceeded, res.Err)
+	}
+}
+
+func TestRunContextInitError(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.init = func(Environment) error {
+		return errors.New("init error")
+	}
+
+	// act
+	res, err := RunContext(context.Background(), prg)
+
+	// assert
+	if err == nil || err.Error() != "init error" {
+		t.Fatalf("RunContext error, want: init error got: %v", err)
+	}
+	if res.Phase != PhaseInit {
+		t.Errorf("Phase, want: %v got: %v", PhaseInit, res.Phase)
+	}
+	if res.Cause != CauseNone {
+		t.Errorf("Cause, want: %v got: %v", CauseNone, res.Cause)
+	}
+	if startCalled != 0 {
+		t.Errorf("startCalled, want: 0 got: %d", startCalled)
+	}
+}
+
+func TestRunHooksAndLogger(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var calls []string
+	var startCalled, stopCalled, initCalled int
+	prg := &reloadProgram{
+		mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled),
+		reload: func() error {
+			calls = append(calls, "reload")
+			return errors.New("bad config")
+		},
+	}
+	prg.start = func() error {
+		calls = append(calls, "start")
+		return nil
+	}
+	prg.stop = func() error {
+		calls = append(calls, "stop")
+		return nil
+	}
+
+	logger := &recordingLogger{}
+
+	go func() {
+		sigChan <- syscall.SIGHUP
+		sigChan <- syscall.SIGTERM
+	}()
+
+	// act
+	err := Run(prg,
+		WithName("awesome"),
+		WithLogger(logger),
+		WithOnReady(func() {
+			calls = append(calls, "ready")
+		}),
+		WithOnExit(func(res Result, err error) {
+			calls = append(calls, fmt.Sprintf("exit %v %v", res.Cause, err))
+		}),
+	)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"start", "ready", "reload", "stop", "exit signal <nil>"}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("calls, want: %q got: %q", want, calls)
+	}
+
+	wantLog := []string{"svc: awesome: reload failed: bad config"}
+	if !reflect.DeepEqual(wantLog, logger.messages) {
+		t.Errorf("log, want: %q got: %q", wantLog, logger.messages)
+	}
+}
+
+func TestRunStartTimeout(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.start = func() error {
+		<-release
+		return nil
+	}
+
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+
+	// act
+	res, err := RunContext(context.Background(), prg, WithStartTimeout(10*time.Millisecond), WithStackDumpFile(path))
+
+	// assert
+	if !errors.Is(err, ErrStartTimeout) {
+		t.Fatalf("RunContext, want: %v got: %v", ErrStartTimeout, err)
+	}
+	if res.Phase != PhaseStart {
+		t.Errorf("Phase, want: %v got: %v", PhaseStart, res.Phase)
+	}
+	if stopCalled != 0 {
+		t.Errorf("stopCalled, want: 0 got: %d", stopCalled)
 	}
 }
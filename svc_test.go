@@ -1,5 +1,12 @@
 package svc
 
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
 type mockProgram struct {
 	start func() error
 	stop  func() error
@@ -34,3 +41,54 @@ func makeProgram(startCalled, stopCalled, initCalled *int) *mockProgram {
 		},
 	}
 }
+
+// setenv sets an environment variable for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	prev, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		var err error
+		if ok {
+			err = os.Setenv(key, prev)
+		} else {
+			err = os.Unsetenv(key)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// closeOnCleanup closes c when the test finishes.
+func closeOnCleanup(t *testing.T, c io.Closer) {
+	t.Helper()
+
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+type reloadProgram struct {
+	*mockProgram
+	reload func() error
+}
+
+func (p *reloadProgram) Reload() error {
+	return p.reload()
+}
+
+type contextProgram struct {
+	*mockProgram
+	ctx context.Context
+}
+
+func (p *contextProgram) Context() context.Context {
+	return p.ctx
+}
@@ -4,10 +4,13 @@ package svc
 
 import (
 	"context"
+	"errors"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
 	"syscall"
+	"time"
 
 	wsvc "golang.org/x/sys/windows/svc"
 )
@@ -16,14 +19,23 @@ import (
 var svcIsWindowsService = wsvc.IsWindowsService
 var svcRun = wsvc.Run
 
+var defaultStopSignals = []os.Signal{syscall.SIGINT}
+
+// Reload is requested through the SCM on Windows, not with signals
+var defaultReloadSignals []os.Signal
+
 type windowsService struct {
 	i                Service
 	errSync          sync.Mutex
 	stopStartErr     error
+	result           Result
 	isWindowsService bool
-	signals          []os.Signal
 	Name             string
 	ctx              context.Context
+	svcCtx           context.Context
+	opts             *options
+	*failures
+	*states
 }
 
 // Run runs an implementation of the Service interface.
@@ -39,47 +51,100 @@ type windowsService struct {
 //
 // The sig parameter is to keep parity with the non-Windows API. Only syscall.SIGINT
 // (Ctrl+C) can be handled on Windows. Nevertheless, you can override the default
-// signals which are handled by specifying sig.
+// signals which are handled by specifying sig. Options may be passed in sig along
+// with the signals to handle.
+//
+// Run is RunContext with context.Background(), returning only the error.
 func Run(service Service, sig ...os.Signal) error {
-	var err error
+	_, err := RunContext(context.Background(), service, signalOptions(sig)...)
+	return err
+}
 
-	isWindowsService, err := svcIsWindowsService()
+// RunContext runs an implementation of the Service interface until the Windows Service
+// is stopped, Ctrl+C is pressed if running from the console, ctx is done, or the context
+// returned by the Service's Context method is done. The Result describes why the service
+// stopped and how long each phase took.
+//
+// The error returned is the error from Init or Start if either failed, otherwise the
+// error from Stop.
+//
+// If the Service implements Reloader, the ParamChange control (for example
+// `sc control <name> paramchange`) reloads the service.
+func RunContext(ctx context.Context, service Service, opts ...Option) (res Result, err error) {
+	o, err := newOptions(opts)
 	if err != nil {
-		return err
+		return res, err
+	}
+
+	defer func() {
+		o.exit(res, err)
+	}()
+
+	if o.daemon != nil {
+		return res, errors.New("svc: daemonizing isn't supported on Windows")
+	}
+
+	if o.user != "" {
+		return res, errors.New("svc: switching user isn't supported on Windows")
+	}
+
+	if o.containerInit == ContainerInitAlways {
+		return res, errors.New("svc: container init mode is only supported on Linux")
+	}
+
+	var inst *instance
+	if o.instance != "" {
+		if inst, err = acquireInstance(o); err != nil {
+			return res, err
+		}
+		defer func() {
+			if err := inst.release(); err != nil {
+				o.logf("releasing instance name: %v", err)
+			}
+		}()
 	}
 
-	if len(sig) == 0 {
-		sig = []os.Signal{syscall.SIGINT}
+	if o.pidFile != "" {
+		return res, errors.New("svc: pidfiles aren't supported on Windows")
 	}
 
-	var ctx context.Context
-	if s, ok := service.(Context); ok {
-		ctx = s.Context()
-	} else {
-		ctx = context.Background()
+	isWindowsService, err := svcIsWindowsService()
+	if err != nil {
+		return res, err
 	}
 
 	ws := &windowsService{
 		i:                service,
 		isWindowsService: isWindowsService,
-		signals:          sig,
+		Name:             o.name,
 		ctx:              ctx,
+		svcCtx:           serviceContext(service),
+		opts:             o,
+		failures:         newFailures(),
+		states:           newStates(),
 	}
+	defer func() {
+		ws.finish(err, o)
+	}()
 
 	if ws.IsWindowsService() {
 		// the working directory for a Windows Service is C:\Windows\System32
 		// this is almost certainly not what the user wants.
 		dir := filepath.Dir(os.Args[0])
 		if err = os.Chdir(dir); err != nil {
-			return err
+			return res, err
 		}
 	}
 
-	if err = service.Init(ws); err != nil {
-		return err
+	res.Phase = PhaseInit
+	err = timed(&res.InitDuration, func() error { return initService(service, ws, o) })
+	ws.setResult(res)
+	if err != nil {
+		return res, err
 	}
 
-	return ws.run()
+	err = ws.run()
+	return ws.getResult(), err
 }
 
 func (ws *windowsService) setError(err error) {
@@ -95,10 +160,70 @@ func (ws *windowsService) getError() error {
 	return err
 }
 
+func (ws *windowsService) setResult(res Result) {
+	ws.errSync.Lock()
+	ws.result = res
+	ws.errSync.Unlock()
+}
+
+func (ws *windowsService) getResult() Result {
+	ws.errSync.Lock()
+	res := ws.result
+	ws.errSync.Unlock()
+	return res
+}
+
+// exitCode returns the service-specific exit code reported to the SCM, chosen by
+// the exit code policy.
+func (ws *windowsService) exitCode(res Result, err error) uint32 {
+	return uint32(ws.opts.exitCode(res, err))
+}
+
 func (ws *windowsService) IsWindowsService() bool {
 	return ws.isWindowsService
 }
 
+// Listeners returns nil; socket activation isn't available on Windows.
+func (ws *windowsService) Listeners() []net.Listener {
+	return nil
+}
+
+// PacketConns returns nil; socket activation isn't available on Windows.
+func (ws *windowsService) PacketConns() []net.PacketConn {
+	return nil
+}
+
+// Identity returns an Identity with UID and GID -1, as for os.Getuid and os.Getgid.
+func (ws *windowsService) Identity() Identity {
+	return Identity{UID: -1, GID: -1}
+}
+
+// IsContainerInit returns false; container init mode is Linux only.
+func (ws *windowsService) IsContainerInit() bool {
+	return false
+}
+
+// PIDFile returns ""; pidfiles aren't supported on Windows.
+func (ws *windowsService) PIDFile() string {
+	return ""
+}
+
+// ListenersWithName returns nil; socket activation isn't available on Windows.
+func (ws *windowsService) ListenersWithName(string) []net.Listener {
+	return nil
+}
+
+// PacketConnsWithName returns nil; socket activation isn't available on Windows.
+func (ws *windowsService) PacketConnsWithName(string) []net.PacketConn {
+	return nil
+}
+
+// Listen opens a new listener as with net.Listen; upgrades aren't supported on
+// Windows, so there are no listeners to inherit.
+func (ws *windowsService) Listen(name, network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
 func (ws *windowsService) run() error {
 	ws.setError(nil)
 	if ws.IsWindowsService() {
@@ -117,53 +242,142 @@ func (ws *windowsService) run() error {
 		return nil
 	}
 
-	err := ws.i.Start()
-	if err != nil {
+	res := ws.getResult()
+	defer func() {
+		ws.setResult(res)
+	}()
+
+	res.Phase = PhaseStart
+	ws.setState(StateStarting, ws.opts)
+	if err := runStart(ws.i, ws.opts, &res); err != nil {
 		return err
 	}
+	ws.setState(StateRunning, ws.opts)
+
+	actions := signalActions(ws.i, ws.opts, ws.opts.stopSignals)
 
 	signalChan := make(chan os.Signal, 1)
-	signalNotify(signalChan, ws.signals...)
+	notifySignals(signalChan, actions)
 
-	select {
-	case <-signalChan:
-	case <-ws.ctx.Done():
+	reload := func(r Reloader) {
+		ws.setState(StateReloading, ws.opts)
+		reloadService(r, ws.opts)
+		ws.setState(StateRunning, ws.opts)
 	}
 
-	err = ws.i.Stop()
+	res.Phase = PhaseRun
+	begin := time.Now()
+	for res.Cause == CauseNone {
+		select {
+		case s := <-signalChan:
+			if handleSignal(ws.i, ws.opts, actions[s], s, reload) {
+				res.Cause, res.Signal = CauseSignal, s
+			}
+		case <-ws.ctx.Done():
+			res.Cause, res.Err = CauseContext, ws.ctx.Err()
+		case <-ws.svcCtx.Done():
+			res.Cause, res.Err = CauseContext, ws.svcCtx.Err()
+		case err := <-ws.failed():
+			res.Cause, res.Err = CauseFailure, err
+		}
+	}
+	res.RunDuration = time.Since(begin)
 
-	return err
+	res.Phase = PhaseStop
+	ws.setState(StateStopping, ws.opts)
+	runStop(ws.i, ws.opts, &res, ws.failures, signalChan, actions)
+
+	return res.err()
+}
+
+// windowsState returns the status reported to the SCM for a service in State s.
+func windowsState(s State) wsvc.State {
+	switch s {
+	case StateInitializing, StateStarting:
+		return wsvc.StartPending
+	case StateRunning, StateReloading:
+		return wsvc.Running
+	case StateStopping:
+		return wsvc.StopPending
+	default:
+		return wsvc.Stopped
+	}
 }
 
 // Execute is invoked by Windows
 func (ws *windowsService) Execute(args []string, r <-chan wsvc.ChangeRequest, changes chan<- wsvc.Status) (bool, uint32) {
-	const cmdsAccepted = wsvc.AcceptStop | wsvc.AcceptShutdown
-	changes <- wsvc.Status{State: wsvc.StartPending}
+	cmdsAccepted := wsvc.AcceptStop | wsvc.AcceptShutdown
+	reloader, canReload := ws.i.(Reloader)
+	if canReload {
+		cmdsAccepted |= wsvc.AcceptParamChange
+	}
+
+	// the status reported to the SCM follows the service's State
+	setState := func(to State) {
+		ws.setState(to, ws.opts)
+		status := wsvc.Status{State: windowsState(to)}
+		if status.State == wsvc.Running {
+			status.Accepts = cmdsAccepted
+		}
+		changes <- status
+	}
+
+	res := ws.getResult()
+	defer func() {
+		ws.setResult(res)
+	}()
+
+	setState(StateStarting)
 
-	if err := ws.i.Start(); err != nil {
+	res.Phase = PhaseStart
+	if err := runStart(ws.i, ws.opts, &res); err != nil {
 		ws.setError(err)
-		return true, 1
+		return true, ws.exitCode(res, err)
 	}
 
-	changes <- wsvc.Status{State: wsvc.Running, Accepts: cmdsAccepted}
+	setState(StateRunning)
 
+	res.Phase = PhaseRun
+	begin := time.Now()
 	for {
 		var c wsvc.ChangeRequest
 		select {
 		case c = <-r:
 		case <-ws.ctx.Done():
 			c = wsvc.ChangeRequest{Cmd: wsvc.Stop}
+			res.Cause, res.Err = CauseContext, ws.ctx.Err()
+		case <-ws.svcCtx.Done():
+			c = wsvc.ChangeRequest{Cmd: wsvc.Stop}
+			res.Cause, res.Err = CauseContext, ws.svcCtx.Err()
+		case err := <-ws.failed():
+			c = wsvc.ChangeRequest{Cmd: wsvc.Stop}
+			res.Cause, res.Err = CauseFailure, err
 		}
 
 		switch c.Cmd {
 		case wsvc.Interrogate:
 			changes <- c.CurrentStatus
+		case wsvc.ParamChange:
+			if canReload {
+				// the SCM has no reloading status, so it isn't told
+				ws.setState(StateReloading, ws.opts)
+				reloadService(reloader, ws.opts)
+				ws.setState(StateRunning, ws.opts)
+			}
 		case wsvc.Stop, wsvc.Shutdown:
-			changes <- wsvc.Status{State: wsvc.StopPending}
-			err := ws.i.Stop()
-			if err != nil {
+			if res.Cause == CauseNone {
+				res.Cause = CauseServiceControl
+			}
+			res.RunDuration = time.Since(begin)
+
+			setState(StateStopping)
+
+			res.Phase = PhaseStop
+			runStop(ws.i, ws.opts, &res, ws.failures, nil, nil)
+			// report failures to the SCM so recovery actions apply
+			if err := res.err(); err != nil {
 				ws.setError(err)
-				return true, 2
+				return true, ws.exitCode(res, err)
 			}
 			return false, 0
 		default:
@@ -3,6 +3,7 @@
 package svc
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
@@ -33,6 +34,7 @@ func setupWinServiceTest(wsf *mockWinServiceFuncs) {
 						for _, registeredSig := range sig {
 							if val == registeredSig {
 								c <- val
+								break
 							}
 						}
 					}
@@ -177,11 +179,31 @@ func TestRunWindowsServiceNonInteractive_StartError(t *testing.T) {
 	equal(t, wsvc.StartPending, changes[0].State)
 
 	equal(t, true, wsf.executeReturnedBool)
-	equal(t, uint32(1), wsf.executeReturnedUInt32)
+	equal(t, uint32(ExitUnavailable), wsf.executeReturnedUInt32)
 
 	equal(t, "start error", wsf.ws.getError().Error())
 }
 
+func TestRunWindowsServiceNonInteractive_ExitCodePolicy(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.start = func() error {
+		return &ExitError{Code: ExitNoPerm, Err: errors.New("access denied")}
+	}
+
+	svcStop := wsvc.Stop
+	wsf, _ := setWindowsServiceFuncs(true, &svcStop)
+
+	// act
+	err := Run(prg)
+
+	// assert
+	equal(t, "access denied", err.Error())
+	equal(t, true, wsf.executeReturnedBool)
+	equal(t, uint32(ExitNoPerm), wsf.executeReturnedUInt32)
+}
+
 func TestRunWindowsServiceInteractive_StartError(t *testing.T) {
 	// arrange
 	var startCalled, stopCalled, initCalled int
@@ -405,11 +427,229 @@ func TestRunWindowsServiceNonInteractive_StopError(t *testing.T) {
 	equal(t, wsvc.StopPending, changes[2].State)
 
 	equal(t, true, wsf.executeReturnedBool)
-	equal(t, uint32(2), wsf.executeReturnedUInt32)
+	equal(t, uint32(ExitSoftware), wsf.executeReturnedUInt32)
 
 	equal(t, "stop error", wsf.ws.getError().Error())
 }
 
+func TestRunWindowsServiceNonInteractive_StopTimeout(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	release := make(chan struct{})
+	defer close(release)
+	prg.stop = func() error {
+		<-release
+		return nil
+	}
+
+	svcStop := wsvc.Stop
+	wsf, _ := setWindowsServiceFuncs(true, &svcStop)
+
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+
+	// act
+	err := Run(prg, WithStopTimeout(10*time.Millisecond), WithStackDumpFile(path))
+
+	// assert
+	equal(t, true, errors.Is(err, ErrStopTimeout))
+
+	equal(t, true, wsf.executeReturnedBool)
+	equal(t, uint32(ExitSoftware), wsf.executeReturnedUInt32)
+}
+
+func TestRunWindowsServiceNonInteractive_ParamChange(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled, reloadCalled int
+	prg := &reloadProgram{
+		mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled),
+		reload: func() error {
+			reloadCalled++
+			return errors.New("reload error")
+		},
+	}
+
+	wsf, changeRequest := setWindowsServiceFuncs(true, nil)
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		changeRequest <- wsvc.ChangeRequest{Cmd: wsvc.ParamChange}
+	})
+
+	time.AfterFunc(100*time.Millisecond, func() {
+		changeRequest <- wsvc.ChangeRequest{Cmd: wsvc.Stop}
+	})
+
+	// act
+	if err := Run(prg); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	changes := wsf.changes
+
+	equal(t, 1, reloadCalled)
+	equal(t, 1, stopCalled)
+
+	equal(t, 3, len(changes))
+	equal(t, wsvc.AcceptStop|wsvc.AcceptShutdown|wsvc.AcceptParamChange, changes[1].Accepts)
+}
+
+func TestRunContextWindowsServiceNonInteractive(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	svcStop := wsvc.Stop
+	setWindowsServiceFuncs(true, &svcStop)
+
+	// act
+	res, err := RunContext(context.Background(), prg)
+
+	// assert
+	assertNil(t, err)
+	equal(t, PhaseStop, res.Phase)
+	equal(t, CauseServiceControl, res.Cause)
+	assertNil(t, res.StopErr)
+}
+
+func TestRunContextWindowsServiceNonInteractive_Hooks(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	svcStop := wsvc.Stop
+	setWindowsServiceFuncs(true, &svcStop)
+
+	var calls []Phase
+	record := func(phase Phase, elapsed time.Duration, err error) {
+		calls = append(calls, phase)
+	}
+
+	// act
+	_, err := RunContext(context.Background(), prg, WithHooks(Hooks{
+		BeforeStart: record,
+		AfterStart:  record,
+		BeforeStop:  record,
+		AfterStop:   record,
+	}))
+
+	// assert
+	assertNil(t, err)
+	equal(t, []Phase{PhaseStart, PhaseStart, PhaseStop, PhaseStop}, calls)
+}
+
+func TestRunContextWindowsServiceNonInteractive_States(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	var changes <-chan StateChange
+	prg.init = func(env Environment) error {
+		changes = env.Subscribe()
+		return nil
+	}
+
+	svcStop := wsvc.Stop
+	wsf, _ := setWindowsServiceFuncs(true, &svcStop)
+
+	// act
+	_, err := RunContext(context.Background(), prg)
+
+	// assert
+	assertNil(t, err)
+	var got []State
+	for change := range changes {
+		got = append(got, change.To)
+	}
+	equal(t, []State{StateStarting, StateRunning, StateStopping, StateStopped}, got)
+
+	var statuses []wsvc.State
+	for _, change := range wsf.changes {
+		statuses = append(statuses, change.State)
+	}
+	equal(t, []wsvc.State{wsvc.StartPending, wsvc.Running, wsvc.StopPending}, statuses)
+}
+
+func TestRunContextWindowsServiceNonInteractive_Canceled(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	wsf, _ := setWindowsServiceFuncs(true, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// act
+	res, err := RunContext(ctx, prg)
+
+	// assert
+	assertNil(t, err)
+	equal(t, CauseContext, res.Cause)
+	equal(t, context.Canceled, res.Err)
+	equal(t, 1, stopCalled)
+	equal(t, wsvc.StopPending, wsf.changes[len(wsf.changes)-1].State)
+}
+
+func TestRunContextInteractive(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	wsf, _ := setWindowsServiceFuncs(false, nil)
+
+	go func() {
+		wsf.sigChan <- os.Interrupt
+	}()
+
+	// act
+	res, err := RunContext(context.Background(), prg)
+
+	// assert
+	assertNil(t, err)
+	equal(t, PhaseStop, res.Phase)
+	equal(t, CauseSignal, res.Cause)
+	equal(t, os.Interrupt, res.Signal)
+	equal(t, 1, stopCalled)
+}
+
+func TestRunWindowsServiceNonInteractive_Options(t *testing.T) {
+	// arrange
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	svcStop := wsvc.Stop
+	wsf, _ := setWindowsServiceFuncs(true, &svcStop)
+
+	var name string
+	svcRun := wsf.svcRun
+	wsf.svcRun = func(n string, handler wsvc.Handler) error {
+		name = n
+		return svcRun(n, handler)
+	}
+
+	var readyCalled int
+	var exitResult Result
+
+	// act
+	err := Run(prg,
+		WithName("awesome"),
+		WithOnReady(func() {
+			readyCalled++
+		}),
+		WithOnExit(func(res Result, err error) {
+			exitResult = res
+		}),
+	)
+
+	// assert
+	assertNil(t, err)
+	equal(t, "awesome", name)
+	equal(t, 1, readyCalled)
+	equal(t, CauseServiceControl, exitResult.Cause)
+}
+
 func TestDefaultSignalHandling(t *testing.T) {
 	signals := []os.Signal{syscall.SIGINT} // default signal handled
 	for _, signal := range signals {
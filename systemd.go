@@ -0,0 +1,133 @@
+// +build !windows
+
+package svc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends state to the service manager listening on NOTIFY_SOCKET.
+// It does nothing when NOTIFY_SOCKET isn't set, which is the case unless the
+// program was started by systemd as a Type=notify unit (or with NotifyAccess).
+//
+// See sd_notify(3) for the list of recognized states.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+
+	// a leading '@' denotes a socket in the abstract namespace,
+	// which the net package handles for us on Linux.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("svc: sd_notify: %w", err)
+	}
+
+	_, err = conn.Write([]byte(state))
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("svc: sd_notify: %w", err)
+	}
+	return nil
+}
+
+// sdReady returns the state sent to the service manager once the service has started.
+func sdReady() string {
+	return fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid())
+}
+
+const sdStopping = "STOPPING=1"
+
+// sdReload calls the Reloader's Reload method, letting systemd know the service
+// is reloading so `systemctl reload` waits for it to finish. Like a failed reload,
+// failing to notify systemd is logged and the service keeps running.
+func sdReload(r Reloader, o *options) {
+	if err := sdNotify("RELOADING=1"); err != nil {
+		o.logf("notifying systemd of the reload: %v", err)
+	}
+	reloadService(r, o)
+	if err := sdNotify("READY=1"); err != nil {
+		o.logf("notifying systemd the reload finished: %v", err)
+	}
+}
+
+// sdWatchdogInterval returns the interval systemd expects watchdog keep-alives
+// within, or 0 if the watchdog isn't enabled for this process.
+func sdWatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	// WATCHDOG_PID is optional; when set it must name this process,
+	// otherwise the variables were meant for a parent process.
+	if s := os.Getenv("WATCHDOG_PID"); s != "" {
+		pid, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("svc: invalid WATCHDOG_PID %q: %w", s, err)
+		}
+		if pid != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("svc: invalid WATCHDOG_USEC %q: %w", usec, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("svc: invalid WATCHDOG_USEC %q", usec)
+	}
+
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// sdWatchdog sends WATCHDOG=1 at half the watchdog interval for as long as service
+// is healthy. A service which doesn't implement HealthChecker is always considered
+// healthy.
+//
+// sdWatchdog returns CauseWatchdog and the error from Health once the service is
+// unhealthy, after sending WATCHDOG=trigger; CauseError if systemd couldn't be
+// notified; or CauseNone when done is closed.
+func sdWatchdog(interval time.Duration, service Service, done <-chan struct{}) (Cause, error) {
+	hc, ok := service.(HealthChecker)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return CauseNone, nil
+		}
+
+		var healthErr error
+		if ok {
+			ctx, cancel := context.WithTimeout(context.Background(), interval/2)
+			healthErr = hc.Health(ctx)
+			cancel()
+		}
+
+		if healthErr != nil {
+			status := strings.Replace(healthErr.Error(), "\n", " ", -1)
+			if err := sdNotify("WATCHDOG=trigger\nSTATUS=health check failed: " + status); err != nil {
+				return CauseError, err
+			}
+			return CauseWatchdog, healthErr
+		}
+
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			return CauseError, err
+		}
+	}
+}
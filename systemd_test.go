@@ -0,0 +1,282 @@
+// +build !windows
+
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// listenNotifySocket creates a unixgram socket standing in for systemd
+// and points NOTIFY_SOCKET at it for the duration of the test.
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setenv(t, "NOTIFY_SOCKET", path)
+
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSdNotifyUnset(t *testing.T) {
+	setenv(t, "NOTIFY_SOCKET", "")
+
+	if err := sdNotify(sdStopping); err != nil {
+		t.Fatalf("sdNotify without NOTIFY_SOCKET, want: <nil> got: %v", err)
+	}
+}
+
+func TestSdNotifyNoListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.sock")
+	setenv(t, "NOTIFY_SOCKET", path)
+
+	if err := sdNotify(sdStopping); err == nil {
+		t.Fatal("sdNotify to missing socket, want: error got: <nil>")
+	}
+}
+
+func TestRunNotifiesSystemd(t *testing.T) {
+	// arrange
+	conn := listenNotifySocket(t)
+
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	go func() {
+		sigChan <- syscall.SIGTERM
+	}()
+
+	// act
+	if err := Run(prg); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if got, want := readNotify(t, conn), fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()); got != want {
+		t.Errorf("first notification, want: %q got: %q", want, got)
+	}
+	if got, want := readNotify(t, conn), "STOPPING=1"; got != want {
+		t.Errorf("second notification, want: %q got: %q", want, got)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+func TestSdReloadNotifyError(t *testing.T) {
+	// arrange
+	setenv(t, "NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+
+	var reloadCalled int
+	r := &reloadProgram{reload: func() error {
+		reloadCalled++
+		return nil
+	}}
+	logger := &recordingLogger{}
+
+	// act
+	sdReload(r, testOptions(t, WithLogger(logger)))
+
+	// assert
+	if reloadCalled != 1 {
+		t.Errorf("reloadCalled, want: 1 got: %d", reloadCalled)
+	}
+	if len(logger.messages) != 2 {
+		t.Fatalf("log messages, want: 2 got: %q", logger.messages)
+	}
+	for i, want := range []string{"svc: notifying systemd of the reload: svc: sd_notify: ", "svc: notifying systemd the reload finished: svc: sd_notify: "} {
+		if !strings.HasPrefix(logger.messages[i], want) {
+			t.Errorf("log, want: %q... got: %q", want, logger.messages[i])
+		}
+	}
+}
+
+func TestRunNotifyReadyError(t *testing.T) {
+	// arrange
+	path := filepath.Join(t.TempDir(), "missing.sock")
+	setenv(t, "NOTIFY_SOCKET", path)
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+
+	// act
+	err := Run(prg)
+
+	// assert
+	if err == nil {
+		t.Fatal("Run with unreachable NOTIFY_SOCKET, want: error got: <nil>")
+	}
+	if startCalled != 1 {
+		t.Errorf("startCalled, want: 1 got: %d", startCalled)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+type healthProgram struct {
+	*mockProgram
+	health func(context.Context) error
+}
+
+func (p *healthProgram) Health(ctx context.Context) error {
+	return p.health(ctx)
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{usec: "", pid: "", want: 0},
+		{usec: "30000000", pid: "", want: 30 * time.Second},
+		{usec: "30000000", pid: pid, want: 30 * time.Second},
+		{usec: "30000000", pid: "1", want: 0},
+		{usec: "abc", pid: pid, wantErr: true},
+		{usec: "0", pid: pid, wantErr: true},
+		{usec: "30000000", pid: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		setenv(t, "WATCHDOG_USEC", tt.usec)
+		setenv(t, "WATCHDOG_PID", tt.pid)
+
+		got, err := sdWatchdogInterval()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("usec=%q pid=%q: err, want error: %v got: %v", tt.usec, tt.pid, tt.wantErr, err)
+		}
+		if got != tt.want {
+			t.Errorf("usec=%q pid=%q: interval, want: %v got: %v", tt.usec, tt.pid, tt.want, got)
+		}
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	// arrange
+	conn := listenNotifySocket(t)
+	setenv(t, "WATCHDOG_USEC", "20000")
+	setenv(t, "WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	sigChan := make(chan os.Signal)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	var healthCalled int32
+	healthErr := errors.New("stuck\nworker")
+	prg := &healthProgram{
+		mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled),
+		health: func(ctx context.Context) error {
+			if atomic.AddInt32(&healthCalled, 1) > 2 {
+				return healthErr
+			}
+			return nil
+		},
+	}
+
+	// act
+	res, err := RunContext(context.Background(), prg)
+
+	// assert
+	if err != healthErr {
+		t.Fatalf("RunContext error, want: %v got: %v", healthErr, err)
+	}
+	if res.Cause != CauseWatchdog {
+		t.Errorf("Cause, want: %v got: %v", CauseWatchdog, res.Cause)
+	}
+	if res.Err != healthErr {
+		t.Errorf("Err, want: %v got: %v", healthErr, res.Err)
+	}
+
+	want := []string{
+		fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()),
+		"WATCHDOG=1",
+		"WATCHDOG=1",
+		"WATCHDOG=trigger\nSTATUS=health check failed: stuck worker",
+		"STOPPING=1",
+	}
+	var states []string
+	for range want {
+		states = append(states, readNotify(t, conn))
+	}
+	if !reflect.DeepEqual(want, states) {
+		t.Errorf("notifications, want: %q got: %q", want, states)
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
+
+func TestRunWatchdogNotifyError(t *testing.T) {
+	// arrange
+	conn := listenNotifySocket(t)
+	setenv(t, "WATCHDOG_USEC", "20000")
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	prg.start = func() error {
+		startCalled++
+		// READY=1 is sent after Start returns, make the
+		// following keep-alive fail by removing the socket.
+		go func() {
+			readNotify(t, conn)
+			if err := os.Remove(os.Getenv("NOTIFY_SOCKET")); err != nil {
+				t.Error(err)
+			}
+		}()
+		return nil
+	}
+
+	// act
+	err := Run(prg)
+
+	// assert
+	if err == nil {
+		t.Fatal("Run with failing watchdog, want: error got: <nil>")
+	}
+	if stopCalled != 1 {
+		t.Errorf("stopCalled, want: 1 got: %d", stopCalled)
+	}
+}
@@ -0,0 +1,224 @@
+// +build !windows
+
+package svc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// The environment variables an upgrade passes to the new process: the pid of the
+// process being replaced, and the descriptor the new process reports on.
+const (
+	upgradePIDEnv = "SVC_UPGRADE_PID"
+	upgradeFDEnv  = "SVC_UPGRADE_FD"
+)
+
+// errUpgradeCanceled is returned by upgrade when it's canceled.
+var errUpgradeCanceled = errors.New("svc: upgrade canceled")
+
+// upgradeArgs returns the command line used to start the new process. It's a
+// variable so tests can start a helper process instead of the test binary.
+var upgradeArgs = func() []string {
+	return os.Args
+}
+
+// upgradeStarted returns the pipe to the parent process if this process was started
+// by ActionUpgrade, or nil otherwise.
+func upgradeStarted() (*parentPipe, error) {
+	pid := os.Getenv(upgradePIDEnv)
+	if err := os.Unsetenv(upgradePIDEnv); err != nil {
+		return nil, err
+	}
+
+	// like LISTEN_PID this keeps a child of the new process from
+	// mistaking the variables, and its descriptors, for its own
+	if pid == "" || pid != strconv.Itoa(os.Getppid()) {
+		return nil, os.Unsetenv(upgradeFDEnv)
+	}
+	return inheritedPipe(upgradeFDEnv)
+}
+
+// handoff holds the listeners opened with the Environment's Listen method.
+type handoff struct {
+	inherited activation
+
+	mu        sync.Mutex
+	taken     []bool
+	names     []string
+	listeners []net.Listener
+}
+
+func newHandoff(inherited activation) *handoff {
+	return &handoff{
+		inherited: inherited,
+		taken:     make([]bool, len(inherited.listeners)),
+	}
+}
+
+// Listen returns the inherited listener named name, or a new one.
+func (h *handoff) Listen(name, network, address string) (net.Listener, error) {
+	if strings.Contains(name, ":") {
+		return nil, fmt.Errorf("svc: invalid listener name %q", name)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, l := range h.inherited.listeners {
+		if l != nil && !h.taken[i] && h.inherited.names[i] == name {
+			h.taken[i] = true
+			h.names, h.listeners = append(h.names, name), append(h.listeners, l)
+			return l, nil
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	h.names, h.listeners = append(h.names, name), append(h.listeners, l)
+	return l, nil
+}
+
+// files returns copies of the listeners' descriptors and their names.
+func (h *handoff) files() ([]*os.File, []string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	files := make([]*os.File, 0, len(h.listeners))
+	for i, l := range h.listeners {
+		filer, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, nil, closeFiles(files, fmt.Errorf("svc: listener %q can't be handed over", h.names[i]))
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, nil, closeFiles(files, err)
+		}
+		files = append(files, f)
+	}
+	return files, append([]string(nil), h.names...), nil
+}
+
+// keepUnixSockets stops the Unix domain listeners removing their socket files when
+// they're closed, since the new process now listens on them.
+func (h *handoff) keepUnixSockets() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, l := range h.listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+// closeFiles closes files, returning err or else the first error from Close.
+func closeFiles(files []*os.File, err error) error {
+	for _, f := range files {
+		if closeErr := f.Close(); closeErr != nil && err == nil && !errors.Is(closeErr, os.ErrClosed) {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// upgrade starts the program's executable again, passing it h's listeners the way
+// systemd passes sockets, and waits for it to report that it started. It returns
+// the new process's pid.
+//
+// Closing cancel sends the new process SIGTERM and makes upgrade return
+// errUpgradeCanceled, whether or not the new process has started.
+func upgrade(h *handoff, cancel <-chan struct{}) (int, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	files, names, err := h.files()
+	if err != nil {
+		return 0, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, closeFiles(files, err)
+	}
+
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   upgradeArgs(),
+		Env:    upgradeEnviron(len(files), names),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		// the listeners start at fd 3, followed by the pipe's write end
+		ExtraFiles: append(files, w),
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, closeFiles(append(files, w, r), err)
+	}
+
+	// close our copy of the write end so reading fails if the new process dies
+	if err := closeFiles(append(files, w), nil); err != nil {
+		return 0, closeFiles([]*os.File{r}, err)
+	}
+
+	// stopping the new process closes the pipe if it hasn't reported yet
+	finished := make(chan struct{})
+	canceled := make(chan error, 1)
+	go func() {
+		defer close(canceled)
+		select {
+		case <-cancel:
+			canceled <- cmd.Process.Signal(syscall.SIGTERM)
+		case <-finished:
+		}
+	}()
+
+	err = readReport(r, cmd, "upgrade")
+	close(finished)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if signalErr, ok := <-canceled; ok {
+		if signalErr != nil && err == nil {
+			return 0, fmt.Errorf("svc: canceling upgrade: %w", signalErr)
+		}
+		return 0, errUpgradeCanceled
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	h.keepUnixSockets()
+	return cmd.Process.Pid, nil
+}
+
+// upgradeEnviron returns the environment of the new process started by upgrade,
+// which is passed n listeners with the given names.
+func upgradeEnviron(n int, names []string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		// the watchdog variables are meant for the new process once it takes over
+		if kv == "WATCHDOG_PID="+strconv.Itoa(os.Getpid()) {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	return append(env,
+		"LISTEN_FDS="+strconv.Itoa(n),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		upgradePIDEnv+"="+strconv.Itoa(os.Getpid()),
+		upgradeFDEnv+"="+strconv.Itoa(3+n),
+	)
+}
@@ -0,0 +1,287 @@
+// +build !windows
+
+package svc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// servePID answers each connection accepted on l with the pid of this process.
+func servePID(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(conn, "%d\n", os.Getpid()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := conn.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// dialPID returns the pid of the process answering on addr.
+func dialPID(t *testing.T, addr string) int {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, conn)
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+// TestUpgradeHelperProcess is the new process started by the upgrade tests.
+func TestUpgradeHelperProcess(t *testing.T) {
+	mode := os.Getenv("GO_SVC_UPGRADE_HELPER")
+	if mode == "" {
+		return
+	}
+
+	// stop eventually if the test doesn't
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var startCalled, stopCalled, initCalled int
+	prg := &contextProgram{mockProgram: makeProgram(&startCalled, &stopCalled, &initCalled), ctx: ctx}
+	var l net.Listener
+	prg.init = func(env Environment) error {
+		switch mode {
+		case "fail":
+			return &ExitError{Code: ExitConfig, Err: errors.New("bad config")}
+		case "slow":
+			// tell the test this process is starting, then never finish
+			pid := []byte(strconv.Itoa(os.Getpid()))
+			if err := ioutil.WriteFile(os.Getenv("GO_SVC_UPGRADE_STARTED"), pid, 0600); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		var err error
+		l, err = env.Listen("web", "tcp", "127.0.0.1:0")
+		return err
+	}
+	prg.start = func() error {
+		go servePID(l)
+		return nil
+	}
+	prg.stop = func() error {
+		return l.Close()
+	}
+
+	Main(prg, WithSignalActions(map[os.Signal]SignalAction{syscall.SIGUSR2: ActionUpgrade}))
+}
+
+// runUpgrade runs a service which upgrades to a helper process, returning the pid
+// of the process answering on the service's listener after the upgrade.
+func runUpgrade(t *testing.T, mode string, sigChan chan os.Signal, opts ...Option) (Result, int, error) {
+	t.Helper()
+
+	upgradeArgs = func() []string {
+		return []string{os.Args[0], "-test.run=^TestUpgradeHelperProcess$"}
+	}
+	t.Cleanup(func() {
+		upgradeArgs = func() []string {
+			return os.Args
+		}
+	})
+	setenv(t, "GO_SVC_UPGRADE_HELPER", mode)
+	mockSignalNotify(sigChan)
+
+	var startCalled, stopCalled, initCalled int
+	prg := makeProgram(&startCalled, &stopCalled, &initCalled)
+	var l net.Listener
+	prg.init = func(env Environment) error {
+		var err error
+		l, err = env.Listen("web", "tcp", "127.0.0.1:0")
+		return err
+	}
+	prg.start = func() error {
+		go servePID(l)
+		go func() {
+			sigChan <- syscall.SIGUSR2
+		}()
+		return nil
+	}
+	prg.stop = func() error {
+		return l.Close()
+	}
+
+	opts = append(opts, WithSignalActions(map[os.Signal]SignalAction{syscall.SIGUSR2: ActionUpgrade}))
+	res, err := RunContext(context.Background(), prg, opts...)
+
+	if res.Cause != CauseUpgrade {
+		return res, 0, err
+	}
+
+	// the old process has closed its listener, so the new one answers
+	pid := dialPID(t, l.Addr().String())
+	if pid != os.Getpid() {
+		t.Cleanup(func() {
+			if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	return res, pid, err
+}
+
+func TestRunContextUpgrade(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+
+	// act
+	res, pid, err := runUpgrade(t, "ok", sigChan)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cause != CauseUpgrade {
+		t.Errorf("Cause, want: %v got: %v", CauseUpgrade, res.Cause)
+	}
+	if res.Signal != syscall.SIGUSR2 {
+		t.Errorf("Signal, want: %v got: %v", syscall.SIGUSR2, res.Signal)
+	}
+	if pid == os.Getpid() {
+		t.Error("listener answered by the old process after the upgrade")
+	}
+}
+
+func TestRunContextUpgradeFailed(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	logger := make(chanLogger, 10)
+	go func() {
+		for msg := range logger {
+			if strings.HasPrefix(msg, "svc: upgrade failed") {
+				if want := "svc: upgrade failed, still running: svc: upgrade: bad config"; msg != want {
+					t.Errorf("log, want: %q got: %q", want, msg)
+				}
+				sigChan <- syscall.SIGTERM
+				return
+			}
+		}
+	}()
+
+	// act
+	res, _, err := runUpgrade(t, "fail", sigChan, WithLogger(logger))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cause != CauseSignal {
+		t.Errorf("Cause, want: %v got: %v", CauseSignal, res.Cause)
+	}
+}
+
+func TestRunContextUpgradeCanceled(t *testing.T) {
+	// arrange
+	sigChan := make(chan os.Signal)
+	started := filepath.Join(t.TempDir(), "started")
+	setenv(t, "GO_SVC_UPGRADE_STARTED", started)
+
+	pids := make(chan int, 1)
+	go func() {
+		defer close(pids)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			b, err := ioutil.ReadFile(started)
+			if err != nil {
+				continue
+			}
+			if pid, err := strconv.Atoi(string(b)); err == nil {
+				pids <- pid
+				break
+			}
+		}
+		sigChan <- syscall.SIGTERM
+	}()
+
+	// act
+	res, _, err := runUpgrade(t, "slow", sigChan)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cause != CauseSignal {
+		t.Errorf("Cause, want: %v got: %v", CauseSignal, res.Cause)
+	}
+	pid, ok := <-pids
+	if !ok {
+		t.Fatal("new process not started")
+	}
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("signalling the new process, want: %v got: %v", syscall.ESRCH, err)
+	}
+}
+
+func TestListenInherited(t *testing.T) {
+	// arrange
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, l)
+	h := newHandoff(activation{names: []string{"web"}, listeners: []net.Listener{l}, packetConns: make([]net.PacketConn, 1)})
+
+	// act
+	inherited, err := h.Listen("web", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := h.Listen("web", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, second)
+	_, invalidErr := h.Listen("a:b", "tcp", "127.0.0.1:0")
+
+	// assert
+	if inherited != l {
+		t.Errorf("first Listen, want: the inherited listener got: %v", inherited.Addr())
+	}
+	if second == l {
+		t.Error("second Listen, want: a new listener got: the inherited one")
+	}
+	if want := `svc: invalid listener name "a:b"`; invalidErr == nil || invalidErr.Error() != want {
+		t.Errorf("Listen, want: %q got: %v", want, invalidErr)
+	}
+
+	files, names, err := h.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		closeOnCleanup(t, f)
+	}
+	if len(files) != 2 || strings.Join(names, ":") != "web:web" {
+		t.Errorf("files, want: 2 named web got: %d named %v", len(files), names)
+	}
+}